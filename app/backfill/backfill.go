@@ -0,0 +1,176 @@
+// Package backfill imports historical gym utilization data into a
+// store, like the data in spreadsheets from before the app existed or
+// the values missing after an outage.
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// batchSize is the max number of values to add to the store at once.
+const batchSize = 500
+
+// Report summarizes an import.
+type Report struct {
+	Read       int // values read
+	Duplicates int // values repeated in the input or already stored
+	Rejected   int // values with a zero capacity or rejected by the validator
+	Suspicious int // values marked as suspicious by the validator
+	Written    int // values added to the store, 0 in dry runs
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%d values read, %d duplicates, %d rejected, "+
+		"%d suspicious, %d written",
+		r.Read, r.Duplicates, r.Rejected, r.Suspicious, r.Written)
+}
+
+// Config is the configuration of an import.
+type Config struct {
+	// Validator checks the values like the scraper does, it can be
+	// nil to accept all of them. It must be a new validator, as it
+	// remembers the values it checks.
+	Validator *scrape.Validator
+	// DryRun does everything but adding the values to the store.
+	DryRun bool
+}
+
+// Import adds the values of a gym to a store, skipping the ones with a
+// zero capacity, like the scraper does, the ones the validator rejects
+// and the ones the store already has. Values with the same timestamp in
+// the input are imported once, the last one wins.
+//
+// Values are validated in chronological order, and the rejected ones
+// are logged with their reasons.
+func Import(
+	ctx context.Context,
+	logger *log.Logger,
+	store storage.Store,
+	config Config,
+	data []*gym.Utilization,
+) (*Report, error) {
+	report := &Report{Read: len(data)}
+
+	if len(data) == 0 {
+		return report, nil
+	}
+
+	data, err := dedupe(data)
+	if err != nil {
+		return nil, err
+	}
+
+	gymName := data[0].Gym
+	first := data[0].Timestamp
+	last := data[len(data)-1].Timestamp
+
+	stored, err := store.GetRange(ctx, first, last.Add(time.Nanosecond))
+	if err != nil {
+		return nil, fmt.Errorf("getting stored values: %v", err)
+	}
+
+	exists := map[int64]bool{}
+
+	for _, s := range stored {
+		if s.Gym == gymName {
+			exists[s.Timestamp.UnixNano()] = true
+		}
+	}
+
+	accepted := make([]*gym.Utilization, 0, len(data))
+
+	for _, d := range data {
+		if exists[d.Timestamp.UnixNano()] {
+			continue
+		}
+
+		if d.Capacity == 0 {
+			logger.Printf("rejected value %v: capacity is 0", d)
+
+			report.Rejected++
+
+			continue
+		}
+
+		if config.Validator != nil {
+			verdict, reasons := config.Validator.Validate(d, nil)
+
+			switch verdict {
+			case scrape.Rejected:
+				logger.Printf("rejected value %v: %s",
+					d, strings.Join(reasons, "; "))
+
+				report.Rejected++
+
+				continue
+			case scrape.Suspicious:
+				d.Suspicious = true
+				report.Suspicious++
+			}
+		}
+
+		accepted = append(accepted, d)
+	}
+
+	report.Duplicates = report.Read - len(accepted) - report.Rejected
+
+	if config.DryRun {
+		return report, nil
+	}
+
+	for len(accepted) != 0 {
+		n := batchSize
+		if n > len(accepted) {
+			n = len(accepted)
+		}
+
+		if err := store.Add(ctx, accepted[:n]...); err != nil {
+			return report, fmt.Errorf("adding values: %v", err)
+		}
+
+		report.Written += n
+		accepted = accepted[n:]
+	}
+
+	return report, nil
+}
+
+// dedupe returns the values sorted chronologically and without
+// repeated timestamps, keeping the last value for each of them. All
+// values must be from the same gym.
+func dedupe(data []*gym.Utilization) ([]*gym.Utilization, error) {
+	sorted := make([]*gym.Utilization, len(data))
+	copy(sorted, data)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	result := make([]*gym.Utilization, 0, len(sorted))
+
+	for _, d := range sorted {
+		if d.Gym != sorted[0].Gym {
+			return nil, fmt.Errorf("values from several gyms: %q and %q",
+				sorted[0].Gym, d.Gym)
+		}
+
+		n := len(result)
+		if n != 0 && result[n-1].Timestamp.Equal(d.Timestamp) {
+			result[n-1] = d
+			continue
+		}
+
+		result = append(result, d)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,301 @@
+package backfill_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/backfill"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+var t0 = time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return t0.Add(time.Duration(minutes) * time.Minute)
+}
+
+func logger(t *testing.T) *log.Logger {
+	return log.New(ioutil.Discard, t.Name(), 0)
+}
+
+// fakeStore is a storage.Store that keeps its values in a slice.
+type fakeStore struct {
+	mutex sync.Mutex
+	data  []*gym.Utilization
+	adds  int
+}
+
+func (s *fakeStore) Add(_ context.Context, data ...*gym.Utilization) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.adds++
+	s.data = append(s.data, data...)
+
+	return nil
+}
+
+func (s *fakeStore) Get(
+	ctx context.Context,
+	since time.Time,
+) ([]*gym.Utilization, error) {
+	return s.GetRange(ctx, since, time.Unix(0, 1<<63-1))
+}
+
+func (s *fakeStore) GetRange(
+	_ context.Context,
+	start, end time.Time,
+) ([]*gym.Utilization, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := []*gym.Utilization{}
+
+	for _, d := range s.data {
+		if !d.Timestamp.Before(start) && d.Timestamp.Before(end) {
+			result = append(result, d)
+		}
+	}
+
+	return result, nil
+}
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+		{Gym: "a", Timestamp: at(10), People: 2, Capacity: 10},
+	}
+
+	subtests := map[string]struct {
+		format backfill.Format
+		input  string
+	}{
+		"csv": {
+			format: backfill.CSV,
+			input: "timestamp,people,capacity\n" +
+				"2020-10-01T18:00:00Z,1,10\n" +
+				"2020-10-01T18:10:00Z,2,10\n",
+		},
+		"csv with other columns in other order": {
+			format: backfill.CSV,
+			input: "Capacity,notes,People,Timestamp\n" +
+				"10,,1,2020-10-01 20:00\n" +
+				"10,\"busy, really\",2,2020-10-01 20:10:00\n",
+		},
+		"jsonl": {
+			format: backfill.JSONL,
+			input: `{"timestamp":"2020-10-01T18:00:00Z","people":1,"capacity":10}` +
+				"\n\n" +
+				`{"timestamp":"2020-10-01 20:10","people":2,"capacity":10}`,
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := backfill.Read(
+				strings.NewReader(test.input), test.format, "a", madrid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRead_ZeroCapacity(t *testing.T) {
+	t.Parallel()
+
+	// read, to be rejected and reported by Import
+	input := "timestamp,people,capacity\n" +
+		"2020-10-01T18:00:00Z,1,0\n"
+
+	got, err := backfill.Read(
+		strings.NewReader(input), backfill.CSV, "a", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(0), People: 1, Capacity: 0},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestRead_Errors(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]struct {
+		format backfill.Format
+		input  string
+	}{
+		"missing column": {
+			format: backfill.CSV,
+			input:  "timestamp,people\n2020-10-01T18:00:00Z,1\n",
+		},
+		"invalid timestamp": {
+			format: backfill.CSV,
+			input:  "timestamp,people,capacity\n01/10/2020,1,10\n",
+		},
+		"negative people": {
+			format: backfill.CSV,
+			input:  "timestamp,people,capacity\n2020-10-01T18:00:00Z,-1,10\n",
+		},
+		"missing key": {
+			format: backfill.JSONL,
+			input:  `{"timestamp":"2020-10-01T18:00:00Z","people":1}`,
+		},
+		"unknown format": {
+			format: "xlsx",
+			input:  "",
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := backfill.Read(
+				strings.NewReader(test.input), test.format, "a", time.UTC)
+			if err == nil {
+				t.Error("unexpected success")
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	stored := &gym.Utilization{Gym: "a", Timestamp: at(10), People: 2, Capacity: 10}
+	otherGym := &gym.Utilization{Gym: "b", Timestamp: at(20), People: 9, Capacity: 10}
+
+	input := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(30), People: 50, Capacity: 10},
+		{Gym: "a", Timestamp: at(20), People: 3, Capacity: 10},
+		{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+		{Gym: "a", Timestamp: at(10), People: 2, Capacity: 10},
+		{Gym: "a", Timestamp: at(20), People: 4, Capacity: 10},
+		{Gym: "a", Timestamp: at(40), People: 9, Capacity: 10},
+		{Gym: "a", Timestamp: at(50), People: 1, Capacity: 0},
+	}
+
+	validator := func() *scrape.Validator {
+		return scrape.NewValidator(
+			scrape.MaxPeopleRatio(1.0, scrape.Rejected),
+			scrape.MaxDeltaPerMinute(0.2, scrape.Suspicious),
+		)
+	}
+
+	subtests := map[string]struct {
+		dryRun     bool
+		wantReport *backfill.Report
+		wantStored []*gym.Utilization
+	}{
+		"writes new values": {
+			wantReport: &backfill.Report{
+				Read:       7,
+				Duplicates: 2,
+				Rejected:   2,
+				Suspicious: 1,
+				Written:    3,
+			},
+			wantStored: []*gym.Utilization{
+				stored,
+				otherGym,
+				{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+				{Gym: "a", Timestamp: at(20), People: 4, Capacity: 10},
+				{Gym: "a", Timestamp: at(40), People: 9, Capacity: 10, Suspicious: true},
+			},
+		},
+		"dry run": {
+			dryRun: true,
+			wantReport: &backfill.Report{
+				Read:       7,
+				Duplicates: 2,
+				Rejected:   2,
+				Suspicious: 1,
+			},
+			wantStored: []*gym.Utilization{stored, otherGym},
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			store := &fakeStore{
+				data: []*gym.Utilization{stored, otherGym},
+			}
+
+			config := backfill.Config{
+				Validator: validator(),
+				DryRun:    test.dryRun,
+			}
+
+			// a copy, as Import marks suspicious values
+			data := make([]*gym.Utilization, len(input))
+			for i, u := range input {
+				c := *u
+				data[i] = &c
+			}
+
+			report, err := backfill.Import(
+				context.Background(), logger(t), store, config, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.wantReport, report); diff != "" {
+				t.Errorf("report: (-want +got)\n%s", diff)
+			}
+
+			if diff := cmp.Diff(test.wantStored, store.data); diff != "" {
+				t.Errorf("stored: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestImport_SeveralGyms(t *testing.T) {
+	t.Parallel()
+
+	data := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+		{Gym: "b", Timestamp: at(10), People: 2, Capacity: 10},
+	}
+
+	_, err := backfill.Import(context.Background(), logger(t),
+		&fakeStore{}, backfill.Config{}, data)
+	if err == nil {
+		t.Error("unexpected success")
+	}
+}
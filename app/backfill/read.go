@@ -0,0 +1,230 @@
+package backfill
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Format is the format of the files to import.
+type Format string
+
+// The supported formats.
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+)
+
+// The column names of CSV files and the keys of JSON Lines objects.
+const (
+	timestampKey = "timestamp"
+	peopleKey    = "people"
+	capacityKey  = "capacity"
+)
+
+// localLayouts are the layouts accepted for timestamps without a time
+// zone, like the ones in spreadsheets, besides RFC 3339.
+var localLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// Read returns the values of a gym in a file, in the order they appear
+// in it.
+//
+// CSV files must have a header with the timestamp, people and capacity
+// columns, in any order, other columns are ignored. JSON Lines files
+// must have an object per line with the same keys. Empty lines are
+// ignored.
+//
+// Timestamps are in RFC 3339 format, or like 2020-10-01 18:00[:05] in
+// the given location. Values with a zero capacity are read, Import
+// rejects them.
+func Read(
+	r io.Reader,
+	format Format,
+	gymName string,
+	loc *time.Location,
+) ([]*gym.Utilization, error) {
+	switch format {
+	case CSV:
+		return readCSV(r, gymName, loc)
+	case JSONL:
+		return readJSONL(r, gymName, loc)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func readCSV(
+	r io.Reader,
+	gymName string,
+	loc *time.Location,
+) ([]*gym.Utilization, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // checked below, with better errors
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return []*gym.Utilization{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, k := range []string{timestampKey, peopleKey, capacityKey} {
+		if _, ok := columns[k]; !ok {
+			return nil, fmt.Errorf("missing %s column in header", k)
+		}
+	}
+
+	result := []*gym.Utilization{}
+
+	// the number of the current row, the header is the first one
+	row := 1
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return result, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		row++
+
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("row %d: want %d fields, got %d",
+				row, len(header), len(record))
+		}
+
+		u, err := parse(
+			gymName,
+			record[columns[timestampKey]],
+			record[columns[peopleKey]],
+			record[columns[capacityKey]],
+			loc,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", row, err)
+		}
+
+		result = append(result, u)
+	}
+}
+
+// jsonlLine is the content of each line of JSON Lines files, numbers
+// are kept as they are to parse them like the CSV ones.
+type jsonlLine struct {
+	Timestamp *string      `json:"timestamp"`
+	People    *json.Number `json:"people"`
+	Capacity  *json.Number `json:"capacity"`
+}
+
+func readJSONL(
+	r io.Reader,
+	gymName string,
+	loc *time.Location,
+) ([]*gym.Utilization, error) {
+	result := []*gym.Utilization{}
+
+	s := bufio.NewScanner(r)
+	line := 0
+
+	for s.Scan() {
+		line++
+
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+
+		var l jsonlLine
+
+		d := json.NewDecoder(strings.NewReader(s.Text()))
+		d.UseNumber()
+
+		if err := d.Decode(&l); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		switch {
+		case l.Timestamp == nil:
+			return nil, fmt.Errorf("line %d: missing %s", line, timestampKey)
+		case l.People == nil:
+			return nil, fmt.Errorf("line %d: missing %s", line, peopleKey)
+		case l.Capacity == nil:
+			return nil, fmt.Errorf("line %d: missing %s", line, capacityKey)
+		}
+
+		u, err := parse(gymName, *l.Timestamp,
+			l.People.String(), l.Capacity.String(), loc)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		result = append(result, u)
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func parse(
+	gymName, timestamp, people, capacity string,
+	loc *time.Location,
+) (*gym.Utilization, error) {
+	ts, err := parseTimestamp(strings.TrimSpace(timestamp), loc)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := strconv.ParseUint(strings.TrimSpace(people), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", peopleKey, err)
+	}
+
+	c, err := strconv.ParseUint(strings.TrimSpace(capacity), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", capacityKey, err)
+	}
+
+	return &gym.Utilization{
+		Gym:       gymName,
+		Timestamp: ts,
+		People:    p,
+		Capacity:  c,
+	}, nil
+}
+
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid %s %q: want RFC 3339 or "+
+		"a local time like %q", timestampKey, s, localLayouts[0])
+}
@@ -0,0 +1,310 @@
+// Package boltdb implements an embedded store for gym utilization
+// data, backed by a single bbolt database file, for deployments with no
+// external services.
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+var (
+	utilizationBucket = []byte("utilization")
+	attemptsBucket    = []byte("attempts")
+)
+
+// Store is a storage.Store in a bbolt database file.
+//
+// Values are stored in a bucket, keyed by their gym name, a zero byte
+// separator and their timestamp, so the values of each gym are sorted
+// chronologically and time ranges can be read without scanning the
+// whole database. Gym names can't contain zero bytes.
+type Store struct {
+	db *bolt.DB
+}
+
+// NewStore opens the database in the given file, creating it if it
+// doesn't exist. Only one store can have the file open at the same
+// time, so this fails if it is already open elsewhere.
+func NewStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{utilizationBucket, attemptsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating buckets: %v", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// utilizationValue is how utilization values are stored, the gym and
+// timestamp are in the key.
+type utilizationValue struct {
+	People     uint64
+	Capacity   uint64
+	Suspicious bool `json:",omitempty"`
+}
+
+// Add implements storage.Store.
+func (s *Store) Add(_ context.Context, data ...*gym.Utilization) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(utilizationBucket)
+
+		for _, d := range data {
+			k, err := key(d.Gym, d.Timestamp)
+			if err != nil {
+				return err
+			}
+
+			v, err := json.Marshal(utilizationValue{
+				People:     d.People,
+				Capacity:   d.Capacity,
+				Suspicious: d.Suspicious,
+			})
+			if err != nil {
+				return fmt.Errorf("encoding value: %v", err)
+			}
+
+			if err := b.Put(k, v); err != nil {
+				return fmt.Errorf("writing value: %v", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Get implements storage.Store.
+func (s *Store) Get(
+	ctx context.Context,
+	since time.Time,
+) ([]*gym.Utilization, error) {
+	return s.GetRange(ctx, since, maxTime)
+}
+
+// maxTime is the latest timestamp the store can hold.
+var maxTime = time.Unix(0, 1<<63-1)
+
+// GetRange implements storage.Store.
+func (s *Store) GetRange(
+	_ context.Context,
+	start, end time.Time,
+) ([]*gym.Utilization, error) {
+	result := []*gym.Utilization{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scan(tx.Bucket(utilizationBucket), start, end,
+			func(name string, ts time.Time, v []byte) error {
+				var value utilizationValue
+				if err := json.Unmarshal(v, &value); err != nil {
+					return fmt.Errorf("decoding value of gym %q at %s: %v",
+						name, ts.Format(time.RFC3339), err)
+				}
+
+				result = append(result, &gym.Utilization{
+					Gym:        name,
+					Timestamp:  ts,
+					People:     value.People,
+					Capacity:   value.Capacity,
+					Suspicious: value.Suspicious,
+				})
+
+				return nil
+			})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// attemptValue is how scraping attempts are stored, the gym and
+// timestamp are in the key.
+type attemptValue struct {
+	Number  int
+	Latency time.Duration
+	Status  int
+	Error   scrape.ErrorClass `json:",omitempty"`
+}
+
+// AddAttempts stores scraping attempts, like influx.Store.AddAttempts.
+func (s *Store) AddAttempts(
+	_ context.Context,
+	attempts ...*scrape.Attempt,
+) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(attemptsBucket)
+
+		for _, a := range attempts {
+			k, err := key(a.Gym, a.Timestamp)
+			if err != nil {
+				return err
+			}
+
+			v, err := json.Marshal(attemptValue{
+				Number:  a.Number,
+				Latency: a.Latency,
+				Status:  a.Status,
+				Error:   a.Error,
+			})
+			if err != nil {
+				return fmt.Errorf("encoding attempt: %v", err)
+			}
+
+			if err := b.Put(k, v); err != nil {
+				return fmt.Errorf("writing attempt: %v", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetAttempts returns the scraping attempts since the given time, like
+// influx.Store.GetAttempts.
+func (s *Store) GetAttempts(
+	_ context.Context,
+	since time.Time,
+) ([]*scrape.Attempt, error) {
+	result := []*scrape.Attempt{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scan(tx.Bucket(attemptsBucket), since, maxTime,
+			func(name string, ts time.Time, v []byte) error {
+				var value attemptValue
+				if err := json.Unmarshal(v, &value); err != nil {
+					return fmt.Errorf("decoding attempt of gym %q at %s: %v",
+						name, ts.Format(time.RFC3339), err)
+				}
+
+				result = append(result, &scrape.Attempt{
+					Gym:       name,
+					Timestamp: ts,
+					Number:    value.Number,
+					Latency:   value.Latency,
+					Status:    value.Status,
+					Error:     value.Error,
+				})
+
+				return nil
+			})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Key returns the key for the value of a gym at a given time: the gym
+// name, a zero byte and the timestamp, so keys are sorted by gym and
+// then chronologically.
+func key(name string, ts time.Time) ([]byte, error) {
+	if strings.IndexByte(name, 0) != -1 {
+		return nil, fmt.Errorf("invalid gym name %q: contains a zero byte",
+			name)
+	}
+
+	k := make([]byte, len(name)+1+8)
+	copy(k, name)
+	putTime(k[len(name)+1:], ts)
+
+	return k, nil
+}
+
+// PutTime encodes the timestamp in 8 bytes that sort like the times
+// they encode: the Unix time in nanoseconds, as big endian, with its
+// sign bit flipped so times before 1970 sort first.
+func putTime(b []byte, ts time.Time) {
+	binary.BigEndian.PutUint64(b, uint64(ts.UnixNano())^(1<<63))
+}
+
+func getTime(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)^(1<<63))).UTC()
+}
+
+// Scan calls fn with the values in the bucket from start (inclusive)
+// to end (exclusive), gym by gym, in chronological order for each gym.
+//
+// Instead of reading the whole bucket, it jumps to the start of the
+// range of each gym and then to the next gym once the end of the range
+// is reached.
+func scan(
+	b *bolt.Bucket,
+	start, end time.Time,
+	fn func(name string, ts time.Time, value []byte) error,
+) error {
+	c := b.Cursor()
+
+	var startBytes, endBytes [8]byte
+	putTime(startBytes[:], start)
+	putTime(endBytes[:], end)
+
+	k, v := c.First()
+	for k != nil {
+		sep := bytes.IndexByte(k, 0)
+		if sep == -1 || len(k) != sep+1+8 {
+			return fmt.Errorf("invalid key %q", k)
+		}
+
+		prefix := k[:sep+1] // the gym name and the separator
+
+		// jump to the start of the range for this gym
+		if bytes.Compare(k[sep+1:], startBytes[:]) < 0 {
+			k, v = c.Seek(append(clone(prefix), startBytes[:]...))
+			if k == nil || !bytes.HasPrefix(k, prefix) {
+				continue
+			}
+		}
+
+		if bytes.Compare(k[sep+1:], endBytes[:]) >= 0 {
+			// jump to the next gym: the names with the same prefix but
+			// a separator greater than zero
+			next := clone(prefix)
+			next[len(next)-1] = 1
+			k, v = c.Seek(next)
+
+			continue
+		}
+
+		if err := fn(string(k[:sep]), getTime(k[sep+1:]), v); err != nil {
+			return err
+		}
+
+		k, v = c.Next()
+	}
+
+	return nil
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
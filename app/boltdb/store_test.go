@@ -0,0 +1,230 @@
+package boltdb_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/boltdb"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// the boltdb store must be usable as a storage.Store.
+var _ storage.Store = &boltdb.Store{}
+
+var t0 = time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return t0.Add(time.Duration(minutes) * time.Minute)
+}
+
+func newStore(t *testing.T) *boltdb.Store {
+	t.Helper()
+
+	s, err := boltdb.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return s
+}
+
+func TestStore_GetRange(t *testing.T) {
+	t.Parallel()
+
+	store := newStore(t)
+	ctx := context.Background()
+
+	data := []*gym.Utilization{
+		{Gym: "b", Timestamp: at(2), People: 20, Capacity: 100},
+		{Gym: "a", Timestamp: at(3), People: 3, Capacity: 10, Suspicious: true},
+		{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10},
+		{Gym: "ab", Timestamp: at(2), People: 200, Capacity: 1000},
+		{Gym: "", Timestamp: at(2), People: 2, Capacity: 10},
+		{Gym: "a", Timestamp: at(2), People: 2, Capacity: 10},
+		{Gym: "b", Timestamp: at(-60), People: 1, Capacity: 100},
+	}
+
+	if err := store.Add(ctx, data...); err != nil {
+		t.Fatal(err)
+	}
+
+	subtests := map[string]struct {
+		start, end time.Time
+		want       []*gym.Utilization
+	}{
+		"everything": {
+			start: at(-1000),
+			end:   at(1000),
+			want: []*gym.Utilization{
+				data[4], data[2], data[5], data[1], data[3], data[6], data[0],
+			},
+		},
+		"start is inclusive, end is exclusive": {
+			start: at(2),
+			end:   at(3),
+			want: []*gym.Utilization{
+				data[4], data[5], data[3], data[0],
+			},
+		},
+		"empty range": {
+			start: at(10),
+			end:   at(20),
+			want:  []*gym.Utilization{},
+		},
+		"before 1970": {
+			start: time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC),
+			end:   at(-30),
+			want:  []*gym.Utilization{data[6]},
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := store.GetRange(ctx, test.start, test.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStore_Get(t *testing.T) {
+	t.Parallel()
+
+	store := newStore(t)
+	ctx := context.Background()
+
+	old := &gym.Utilization{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10}
+	overwritten := &gym.Utilization{Gym: "a", Timestamp: at(2), People: 2, Capacity: 10}
+	newer := &gym.Utilization{Gym: "a", Timestamp: at(2), People: 5, Capacity: 10}
+
+	if err := store.Add(ctx, old, overwritten); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Add(ctx, newer); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(ctx, at(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{newer}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestStore_Persistence(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	data := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10},
+	}
+
+	{
+		store, err := boltdb.NewStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Add(ctx, data...); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := boltdb.NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	got, err := store.Get(ctx, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(data, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestStore_InvalidGymName(t *testing.T) {
+	t.Parallel()
+
+	store := newStore(t)
+
+	u := &gym.Utilization{Gym: "a\x00b", Timestamp: t0, Capacity: 1}
+
+	if err := store.Add(context.Background(), u); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestStore_Attempts(t *testing.T) {
+	t.Parallel()
+
+	store := newStore(t)
+	ctx := context.Background()
+
+	data := []*scrape.Attempt{
+		{
+			Gym:       "a",
+			Timestamp: at(1),
+			Number:    1,
+			Latency:   1500 * time.Millisecond,
+			Status:    503,
+			Error:     scrape.ErrorStatus,
+		},
+		{
+			Gym:       "a",
+			Timestamp: at(2),
+			Number:    2,
+			Latency:   250 * time.Millisecond,
+			Status:    200,
+		},
+	}
+
+	if err := store.AddAttempts(ctx, data...); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetAttempts(ctx, at(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(data[1:], got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
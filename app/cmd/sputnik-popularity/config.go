@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/influx"
+	"github.com/alcortesm/sputnik-popularity/app/schedule"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+type config struct {
+	Scrape   scrapeConfig
+	InfluxDB influxConfig
+	Recent   recentConfig
+	Web      webConfig
+	Refresh  refreshConfig
+	WAL      walConfig
+	Storage  storageConfig
+	Rollup   rollupConfig
+}
+
+// validate checks the combinations of values that are not valid.
+func (c config) validate() error {
+	// batched writes are acknowledged before reaching the database, so
+	// the write-ahead queue would remove them from disk too early and
+	// they would be lost if the write fails.
+	if c.WAL.Dir != "" &&
+		c.Storage.Backend == influxBackend &&
+		c.InfluxDB.BatchSize > 0 {
+		return errors.New("the write-ahead queue can't be used " +
+			"with InfluxDB batched writes")
+	}
+
+	return nil
+}
+
+// storageConfig selects the permanent store of the app.
+type storageConfig struct {
+	Backend  string `default:"influx"` // influxBackend, boltBackend or fileBackend
+	BoltPath string `default:"sputnik.db" split_words:"true"`
+	File     fileStorageConfig
+}
+
+// fileStorageConfig controls the files of the file storage backend,
+// see filestore.Store.
+type fileStorageConfig struct {
+	Dir    string `default:"data"`
+	Format string `default:"csv"` // csv or jsonl
+}
+
+// The valid storage backends.
+const (
+	influxBackend = "influx"
+	boltBackend   = "bolt" // an embedded database, see boltdb.Store
+	fileBackend   = "file" // daily CSV or JSONL files, see filestore.Store
+)
+
+type scrapeConfig struct {
+	Gyms gymsConfig
+	// the single gym to scrape when Gyms is empty, deprecated in favour
+	// of Gyms, see gymList
+	GymName string        `split_words:"true"`
+	GymID   int           `split_words:"true"`
+	URL     string        // default URL for gyms without one
+	Period  time.Duration `default:"10m"` // default period for gyms without one
+	Timeout time.Duration `default:"10s"`
+	// how often to scrape gyms while they are closed, 0 means never
+	ClosedPeriod time.Duration `default:"0" split_words:"true"`
+	Retry        retryConfig
+	Breaker      breakerConfig
+	Adaptive     adaptiveConfig
+	Archive      archiveConfig
+	Validation   validationConfig
+}
+
+// gymList returns the gyms to scrape: Gyms or, for the deployments
+// from before it existed, the single gym in GymName and GymID.
+func (c scrapeConfig) gymList() (gymsConfig, error) {
+	switch {
+	case len(c.Gyms) != 0 && c.GymName != "":
+		return nil, errors.New("SPUTNIK_SCRAPE_GYMS and " +
+			"SPUTNIK_SCRAPE_GYM_NAME are mutually exclusive")
+	case len(c.Gyms) != 0:
+		return c.Gyms, nil
+	case c.GymName != "":
+		return gymsConfig{{
+			Name:   c.GymName,
+			ID:     c.GymID,
+			Source: vendorSource,
+		}}, nil
+	default:
+		return nil, errors.New("missing SPUTNIK_SCRAPE_GYMS " +
+			"(it replaces SPUTNIK_SCRAPE_GYM_NAME and SPUTNIK_SCRAPE_GYM_ID)")
+	}
+}
+
+// validationConfig controls the sanity checks of the scraped values,
+// see scrape.Validator. Each rule has a verdict for the values that
+// break it: "suspicious" values are stored but flagged, "rejected"
+// values are discarded and "accepted" disables the rule. Rules with a
+// zero limit are also disabled. All the rules are disabled by default.
+type validationConfig struct {
+	// max people, as a ratio of the capacity
+	MaxPeopleRatio        float64        `split_words:"true"`
+	MaxPeopleRatioVerdict scrape.Verdict `default:"rejected" split_words:"true"`
+	// max change in people per minute between consecutive values
+	MaxDeltaPerMinute        float64        `split_words:"true"`
+	MaxDeltaPerMinuteVerdict scrape.Verdict `default:"suspicious" split_words:"true"`
+	// allowed capacity range
+	MinCapacity     uint64         `split_words:"true"`
+	MaxCapacity     uint64         `split_words:"true"`
+	CapacityVerdict scrape.Verdict `default:"rejected" split_words:"true"`
+	// for capacities that change between consecutive values
+	CapacityChangeVerdict scrape.Verdict `default:"accepted" split_words:"true"`
+	// for responses with unknown fields
+	UnknownFieldsVerdict scrape.Verdict `default:"accepted" split_words:"true"`
+}
+
+// archiveConfig controls the recording of the raw responses from the
+// gyms, see scrape.Archive. Each gym gets its own subdirectory.
+type archiveConfig struct {
+	Dir      string // empty to disable recording
+	MaxFiles int    `default:"1000" split_words:"true"` // per gym
+}
+
+// adaptiveConfig controls how the scrape period adapts to the changes
+// in utilization, see schedule.Adaptive. Missing bounds default to the
+// period of each gym, so by default the period is fixed.
+type adaptiveConfig struct {
+	MinPeriod    time.Duration `split_words:"true"`
+	MaxPeriod    time.Duration `split_words:"true"`
+	TargetChange float64       `default:"5" split_words:"true"`
+}
+
+type breakerConfig struct {
+	FailureThreshold int           `default:"5" split_words:"true"`
+	OpenTimeout      time.Duration `default:"30m" split_words:"true"`
+}
+
+type retryConfig struct {
+	MaxAttempts    int           `default:"4" split_words:"true"`
+	InitialBackoff time.Duration `default:"2s" split_words:"true"`
+	MaxBackoff     time.Duration `default:"1m" split_words:"true"`
+	Multiplier     float64       `default:"2"`
+	Jitter         float64       `default:"0.2"`
+}
+
+// gymsConfig is the list of gyms to scrape. It is read from a JSON
+// array of objects like this one:
+//
+//	{
+//		"name": "sputnik",
+//		"id": 121,
+//		"url": "http://...",
+//		"period": "5m",
+//		"timezone": "Europe/Amsterdam",
+//		"hours": {"mon": "09:00-23:00", "sat": "10:00-22:00"},
+//		"source": "json",
+//		"json": {
+//			"method": "GET",
+//			"body": "",
+//			"people": "data.people",
+//			"capacity": "data.capacity"
+//		}
+//	}
+//
+// The url and period are optional, the scrape config defaults will be
+// used for gyms without them. The opening hours are also optional,
+// gyms without them are considered always open; see schedule.Parse for
+// their format.
+//
+// The source says how to scrape the gym: "vendor" (the default) for
+// gyms using the booking vendor of the original gym, "json" for a
+// generic JSON API configured in the json field, see
+// scrape.JSONSource, or "html" for a web page configured in the html
+// field, like this:
+//
+//	"html": {
+//		"people": "(\\d+) / \\d+ climbers",
+//		"capacity": "\\d+ / (\\d+) climbers",
+//		"raw": false
+//	}
+//
+// See scrape.HTMLSource for details.
+type gymsConfig []gymConfig
+
+type gymConfig struct {
+	Name     string
+	ID       int
+	URL      string
+	Period   time.Duration
+	Schedule *schedule.Schedule
+	Source   string
+	JSON     scrape.JSONConfig
+	HTML     scrape.HTMLConfig
+}
+
+// The valid sources of gym configs.
+const (
+	vendorSource = "vendor"
+	jsonSource   = "json"
+	htmlSource   = "html"
+)
+
+// Decode implements envconfig.Decoder.
+func (gg *gymsConfig) Decode(value string) error {
+	var raw []struct {
+		Name     string            `json:"name"`
+		ID       int               `json:"id"`
+		URL      string            `json:"url"`
+		Period   string            `json:"period"`
+		Timezone string            `json:"timezone"`
+		Hours    map[string]string `json:"hours"`
+		Source   string            `json:"source"`
+		JSON     struct {
+			Method   string `json:"method"`
+			Body     string `json:"body"`
+			People   string `json:"people"`
+			Capacity string `json:"capacity"`
+		} `json:"json"`
+		HTML struct {
+			People   string `json:"people"`
+			Capacity string `json:"capacity"`
+			Raw      bool   `json:"raw"`
+		} `json:"html"`
+	}
+
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return fmt.Errorf("decoding JSON: %v", err)
+	}
+
+	if len(raw) == 0 {
+		return errors.New("empty list of gyms")
+	}
+
+	result := make(gymsConfig, len(raw))
+	seen := make(map[string]bool, len(raw))
+
+	for i, r := range raw {
+		if r.Name == "" {
+			return fmt.Errorf("gym #%d: empty name", i)
+		}
+
+		if seen[r.Name] {
+			return fmt.Errorf("gym #%d: repeated name %q", i, r.Name)
+		}
+		seen[r.Name] = true
+
+		result[i] = gymConfig{
+			Name:   r.Name,
+			ID:     r.ID,
+			URL:    r.URL,
+			Source: r.Source,
+			JSON: scrape.JSONConfig{
+				Method:        r.JSON.Method,
+				Body:          r.JSON.Body,
+				PeopleField:   r.JSON.People,
+				CapacityField: r.JSON.Capacity,
+			},
+			HTML: scrape.HTMLConfig{
+				PeoplePattern:   r.HTML.People,
+				CapacityPattern: r.HTML.Capacity,
+				Raw:             r.HTML.Raw,
+			},
+		}
+
+		switch r.Source {
+		case "":
+			result[i].Source = vendorSource
+		case vendorSource, jsonSource, htmlSource:
+		default:
+			return fmt.Errorf("gym %q: unknown source %q", r.Name, r.Source)
+		}
+
+		if r.Period != "" {
+			period, err := time.ParseDuration(r.Period)
+			if err != nil {
+				return fmt.Errorf("gym %q: parsing period: %v", r.Name, err)
+			}
+
+			if period <= 0 {
+				return fmt.Errorf("gym %q: period must be >0, was %v",
+					r.Name, period)
+			}
+
+			result[i].Period = period
+		}
+
+		sched, err := schedule.Parse(r.Timezone, r.Hours)
+		if err != nil {
+			return fmt.Errorf("gym %q: parsing opening hours: %v",
+				r.Name, err)
+		}
+
+		result[i].Schedule = sched
+	}
+
+	*gg = result
+
+	return nil
+}
+
+// forPeriod returns the adaptive config for a gym with the given scrape
+// period, using the period as the default bounds.
+func (c adaptiveConfig) forPeriod(period time.Duration) schedule.AdaptiveConfig {
+	result := schedule.AdaptiveConfig(c)
+
+	if result.MinPeriod == 0 {
+		result.MinPeriod = period
+	}
+
+	if result.MaxPeriod == 0 {
+		result.MaxPeriod = period
+	}
+
+	return result
+}
+
+// newValidator returns a validator with the enabled rules or nil if
+// they are all disabled. Validators keep track of the previous value,
+// so each gym needs its own.
+func (c validationConfig) newValidator() *scrape.Validator {
+	var rules []scrape.Rule
+
+	add := func(enabled bool, verdict scrape.Verdict, rule func(scrape.Verdict) scrape.Rule) {
+		if enabled && verdict != scrape.Accepted {
+			rules = append(rules, rule(verdict))
+		}
+	}
+
+	add(c.MaxPeopleRatio > 0, c.MaxPeopleRatioVerdict,
+		func(v scrape.Verdict) scrape.Rule {
+			return scrape.MaxPeopleRatio(c.MaxPeopleRatio, v)
+		})
+
+	add(c.MaxDeltaPerMinute > 0, c.MaxDeltaPerMinuteVerdict,
+		func(v scrape.Verdict) scrape.Rule {
+			return scrape.MaxDeltaPerMinute(c.MaxDeltaPerMinute, v)
+		})
+
+	add(c.MinCapacity > 0 || c.MaxCapacity > 0, c.CapacityVerdict,
+		func(v scrape.Verdict) scrape.Rule {
+			return scrape.CapacityRange(c.MinCapacity, c.MaxCapacity, v)
+		})
+
+	add(true, c.CapacityChangeVerdict, scrape.StableCapacity)
+
+	add(true, c.UnknownFieldsVerdict, scrape.NoUnknownFields)
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return scrape.NewValidator(rules...)
+}
+
+// withDefaults returns a copy of the gym config where the missing
+// values have been replaced with the defaults in the scrape config.
+func (g gymConfig) withDefaults(defaults scrapeConfig) gymConfig {
+	if g.URL == "" {
+		g.URL = defaults.URL
+	}
+
+	if g.Period == 0 {
+		g.Period = defaults.Period
+	}
+
+	return g
+}
+
+// influxConfig is only required when using the influx storage
+// backend, see validate.
+type influxConfig struct {
+	URL         string
+	TokenWrite  string `split_words:"true"`
+	TokenRead   string `split_words:"true"`
+	Org         string `default:"tsDemo"`
+	Bucket      string `default:"sputnik_popularity"`
+	Measurement string `default:"capacity_utilization"`
+	// AttemptsMeasurement is where the scraping attempts are stored
+	AttemptsMeasurement string `default:"scrape_attempts" split_words:"true"`
+	// batched asynchronous writes, disabled by a zero batch size; see
+	// influx.Config. Batched writes are reported as successful before
+	// reaching the database, so they can't be used with the
+	// write-ahead queue.
+	BatchSize     uint          `split_words:"true"`
+	FlushInterval time.Duration `default:"10s" split_words:"true"`
+	// the optional fields to add to every point, see influxConfig; the
+	// gym name and the schema version are always added as tags
+	Fields []string `default:"gym_id,source,version"`
+	// only read the points with these field or tag values, like
+	// "source:json"
+	Filter map[string]string
+	// the gym of the untagged points from before the app supported
+	// several gyms, and whether to tag them with it at startup; see
+	// influx.Store.MigrateLegacy
+	LegacyGym     string `split_words:"true"`
+	MigrateLegacy bool   `split_words:"true"`
+	// where to store the rollups, if they are enabled
+	HourlyMeasurement string `default:"capacity_utilization_1h" split_words:"true"`
+	DailyMeasurement  string `default:"capacity_utilization_1d" split_words:"true"`
+}
+
+// influxConfig returns the config of the influx store for the given
+// gyms.
+func (c influxConfig) influxConfig(gyms []gymConfig) (influx.Config, error) {
+	result := influx.Config{
+		URL:                 c.URL,
+		TokenWrite:          c.TokenWrite,
+		TokenRead:           c.TokenRead,
+		Org:                 c.Org,
+		Bucket:              c.Bucket,
+		Measurement:         c.Measurement,
+		AttemptsMeasurement: c.AttemptsMeasurement,
+		BatchSize:           c.BatchSize,
+		FlushInterval:       c.FlushInterval,
+		Fields:              map[string]string{},
+		GymFields:           map[string]map[string]string{},
+		Filter:              c.Filter,
+		LegacyGym:           c.LegacyGym,
+	}
+
+	for _, gc := range gyms {
+		result.GymFields[gc.Name] = map[string]string{}
+	}
+
+	for _, field := range c.Fields {
+		switch field {
+		case influx.VersionFieldKey:
+			result.Fields[field] = version
+		case influx.GymIDFieldKey:
+			for _, gc := range gyms {
+				result.GymFields[gc.Name][field] = strconv.Itoa(gc.ID)
+			}
+		case influx.SourceFieldKey:
+			for _, gc := range gyms {
+				result.GymFields[gc.Name][field] = gc.Source
+			}
+		default:
+			return influx.Config{}, fmt.Errorf("unknown field %q", field)
+		}
+	}
+
+	return result, nil
+}
+
+// validate checks the values that are required to use InfluxDB.
+func (c influxConfig) validate() error {
+	switch {
+	case c.URL == "":
+		return errors.New("missing URL")
+	case c.TokenWrite == "":
+		return errors.New("missing write token")
+	case c.TokenRead == "":
+		return errors.New("missing read token")
+	default:
+		return nil
+	}
+}
+
+type recentConfig struct {
+	Retention time.Duration `default:"168h" split_words:"true"` // 168h is 1 week
+	// where to snapshot the recent stores, to restore them at startup;
+	// empty to disable snapshots
+	SnapshotDir    string        `split_words:"true"`
+	SnapshotPeriod time.Duration `default:"5m" split_words:"true"`
+	// also forget the values older than the current time minus the
+	// retention, every sweep period; see recent.NewClockStore
+	WallClock   bool          `split_words:"true"`
+	SweepPeriod time.Duration `default:"1m" split_words:"true"`
+}
+
+type webConfig struct {
+	Port            int           `default:"8080"`
+	ReadTimeout     time.Duration `default:"10s"`
+	WriteTimeout    time.Duration `default:"10s"`
+	ShutdownTimeout time.Duration `default:"10s"`
+}
+
+// walConfig controls the write-ahead queue in front of InfluxDB, see
+// wal.Queue.
+type walConfig struct {
+	Dir        string        // empty to write to InfluxDB directly
+	MinBackoff time.Duration `default:"1s" split_words:"true"`
+	MaxBackoff time.Duration `default:"5m" split_words:"true"`
+}
+
+// rollupConfig controls the hourly and daily rollups of the data, see
+// rollup.Roller. Only the influx storage backend supports them.
+type rollupConfig struct {
+	Enabled  bool
+	Interval time.Duration `default:"10m"`
+	Lookback time.Duration `default:"24h"`
+	Backfill time.Duration `default:"8760h"` // 8760h is 1 year
+}
+
+type refreshConfig struct {
+	Period time.Duration `default:"1h" split_words:"true"`
+}
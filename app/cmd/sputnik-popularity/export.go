@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/export"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+	"github.com/alcortesm/sputnik-popularity/app/web"
+)
+
+// runExport runs the export subcommand, which writes the data in a
+// time range from the configured store to the standard output or a
+// file, see export.Writer.
+func runExport(
+	ctx context.Context,
+	logger *log.Logger,
+	c config,
+	gyms []gymConfig,
+	args []string,
+) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+
+	var (
+		rawStart = flags.String("start", "", "the start of the time "+
+			"range, inclusive, like 2020-03-01 (in UTC) or an RFC 3339 "+
+			"timestamp (required)")
+		rawEnd = flags.String("end", "", "the end of the time range, "+
+			"exclusive, like the start (defaults to now)")
+		gymNames = flags.String("gyms", "", "the comma separated gyms "+
+			"to export (defaults to all of them)")
+		format = flags.String("format", string(export.CSV),
+			"the format of the data: csv, jsonl or line (for the "+
+				"InfluxDB line protocol)")
+		window = flags.Duration("window", 0, "aggregate the values "+
+			"in time windows of this duration, like 1h (0 to export "+
+			"all the values)")
+		fn = flags.String("agg", string(storage.Mean), "the function "+
+			"to aggregate the values with: mean, max, min or last")
+		output = flags.String("output", "-", "the file to write to, "+
+			"- for the standard output")
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(),
+			"usage: %s export -start TIME [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	if *rawStart == "" {
+		return errors.New("missing start")
+	}
+
+	start, err := web.ParseTime(*rawStart)
+	if err != nil {
+		return fmt.Errorf("invalid start: %v", err)
+	}
+
+	end := time.Now()
+
+	if *rawEnd != "" {
+		end, err = web.ParseTime(*rawEnd)
+		if err != nil {
+			return fmt.Errorf("invalid end: %v", err)
+		}
+	}
+
+	if !start.Before(end) {
+		return fmt.Errorf("start (%s) must be before end (%s)",
+			start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	var aggregate storage.Aggregate
+	if err := aggregate.UnmarshalText([]byte(*fn)); err != nil {
+		return err
+	}
+
+	if *window < 0 {
+		return fmt.Errorf("invalid window %v: must be >=0", *window)
+	}
+
+	// validate the format before reading the data
+	if _, err := export.NewWriter(ioutil.Discard, export.Format(*format),
+		c.InfluxDB.Measurement); err != nil {
+		return err
+	}
+
+	// the migration would race with the export and be interrupted
+	// when it finishes
+	c.InfluxDB.MigrateLegacy = false
+
+	db, closeDB, err := newDatabase(logger, c, gyms)
+	if err != nil {
+		return fmt.Errorf("creating database: %v", err)
+	}
+	defer closeDB()
+
+	data, err := getExportData(ctx, db, start, end, *window, aggregate)
+	if err != nil {
+		return err
+	}
+
+	if *gymNames != "" {
+		data = filterGyms(data, strings.Split(*gymNames, ","))
+	}
+
+	sort.SliceStable(data, func(i, j int) bool {
+		if data[i].Gym != data[j].Gym {
+			return data[i].Gym < data[j].Gym
+		}
+
+		return data[i].Timestamp.Before(data[j].Timestamp)
+	})
+
+	if *output == "-" {
+		return writeExport(os.Stdout, export.Format(*format),
+			c.InfluxDB.Measurement, data)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := writeExport(f, export.Format(*format),
+		c.InfluxDB.Measurement, data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	logger.Printf("export: %d values written to %s", len(data), *output)
+
+	return nil
+}
+
+// getExportData returns the values in the time range, aggregated in
+// windows of the given duration unless it is 0. Stores that can't
+// aggregate their values are downsampled in memory.
+func getExportData(
+	ctx context.Context,
+	db storage.Store,
+	start, end time.Time,
+	window time.Duration,
+	fn storage.Aggregate,
+) ([]*gym.Utilization, error) {
+	if aggregator, ok := db.(storage.Aggregator); ok && window != 0 {
+		data, err := aggregator.GetAggregated(ctx, start, end, window, fn)
+		if err != nil {
+			return nil, fmt.Errorf("getting aggregated data: %v", err)
+		}
+
+		return data, nil
+	}
+
+	data, err := db.GetRange(ctx, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("getting data: %v", err)
+	}
+
+	if window == 0 {
+		return data, nil
+	}
+
+	return storage.Downsample(data, window, fn)
+}
+
+// filterGyms returns the values of the given gyms.
+func filterGyms(data []*gym.Utilization, names []string) []*gym.Utilization {
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[strings.TrimSpace(n)] = true
+	}
+
+	result := make([]*gym.Utilization, 0, len(data))
+
+	for _, d := range data {
+		if wanted[d.Gym] {
+			result = append(result, d)
+		}
+	}
+
+	return result
+}
+
+func writeExport(
+	w io.Writer,
+	format export.Format,
+	measurement string,
+	data []*gym.Utilization,
+) error {
+	ew, err := export.NewWriter(w, format, measurement)
+	if err != nil {
+		return err
+	}
+
+	if err := ew.Write(data...); err != nil {
+		return err
+	}
+
+	return ew.Flush()
+}
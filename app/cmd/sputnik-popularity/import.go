@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/backfill"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// runImport runs the import subcommand, which adds the historical data
+// of a gym in CSV or JSON Lines files to the configured store, see
+// backfill.Import. The data is read from the standard input if there
+// are no files.
+func runImport(
+	ctx context.Context,
+	logger *log.Logger,
+	c config,
+	gyms []gymConfig,
+	args []string,
+) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+
+	var (
+		gymName = flags.String("gym", "", "the gym of the data (required)")
+		format  = flags.String("format", "", "the format of the data: "+
+			"csv or jsonl (defaults to the file extension, or csv)")
+		timezone = flags.String("timezone", "UTC",
+			"the timezone of the timestamps without one")
+		dryRun = flags.Bool("dry-run", false,
+			"validate the data and report what would be imported, "+
+				"without importing it")
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(),
+			"usage: %s import -gym NAME [flags] [FILE...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	if *gymName == "" {
+		return errors.New("missing gym")
+	}
+
+	known := false
+	for _, gc := range gyms {
+		known = known || gc.Name == *gymName
+	}
+
+	if !known {
+		return fmt.Errorf("unknown gym %q: it must be in the configured "+
+			"gyms", *gymName)
+	}
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return fmt.Errorf("loading timezone: %v", err)
+	}
+
+	var data []*gym.Utilization
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	for _, path := range files {
+		values, err := readImportFile(path, backfill.Format(*format),
+			*gymName, loc)
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", path, err)
+		}
+
+		data = append(data, values...)
+	}
+
+	// the migration would race with the import and be interrupted
+	// when it finishes
+	c.InfluxDB.MigrateLegacy = false
+
+	db, closeDB, err := newDatabase(logger, c, gyms)
+	if err != nil {
+		return fmt.Errorf("creating database: %v", err)
+	}
+	defer closeDB()
+
+	config := backfill.Config{
+		Validator: c.Scrape.Validation.newValidator(),
+		DryRun:    *dryRun,
+	}
+
+	report, err := backfill.Import(ctx, logger, db, config, data)
+	if report != nil {
+		prefix := "import"
+		if *dryRun {
+			prefix = "import (dry run)"
+		}
+
+		logger.Printf("%s: %v", prefix, report)
+	}
+
+	if err != nil || *dryRun || report.Written == 0 {
+		return err
+	}
+
+	return rollUpImported(ctx, logger, c, db, data)
+}
+
+// rollUpImported recomputes the rollups of the time range of the
+// imported data, if rollups are enabled, so the data is not missing
+// from the long time ranges read from them.
+func rollUpImported(
+	ctx context.Context,
+	logger *log.Logger,
+	c config,
+	db storage.Store,
+	data []*gym.Utilization,
+) error {
+	roller, err := newRoller(logger, c, db)
+	if err != nil {
+		return fmt.Errorf("creating rollups: %v", err)
+	}
+
+	if roller == nil {
+		return nil
+	}
+
+	first, last := data[0].Timestamp, data[0].Timestamp
+	for _, d := range data[1:] {
+		if d.Timestamp.Before(first) {
+			first = d.Timestamp
+		}
+
+		if d.Timestamp.After(last) {
+			last = d.Timestamp
+		}
+	}
+
+	if err := roller.RollUpRange(ctx, first, last.Add(time.Nanosecond)); err != nil {
+		return fmt.Errorf("updating rollups: %v", err)
+	}
+
+	logger.Printf("import: rollups updated from %s to %s",
+		first.Format(time.RFC3339), last.Format(time.RFC3339))
+
+	return nil
+}
+
+// readImportFile reads the data to import from a file, or from the
+// standard input if the path is "-". An empty format means the format
+// in the file extension, or CSV.
+func readImportFile(
+	path string,
+	format backfill.Format,
+	gymName string,
+	loc *time.Location,
+) ([]*gym.Utilization, error) {
+	if format == "" {
+		format = backfill.CSV
+
+		ext := strings.TrimPrefix(filepath.Ext(path), ".")
+		if backfill.Format(ext) == backfill.JSONL {
+			format = backfill.JSONL
+		}
+	}
+
+	var r io.Reader = os.Stdin
+
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	return backfill.Read(r, format, gymName, loc)
+}
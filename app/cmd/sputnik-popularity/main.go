@@ -6,61 +6,33 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/alcortesm/sputnik-popularity/app/boltdb"
+	"github.com/alcortesm/sputnik-popularity/app/filestore"
 	"github.com/alcortesm/sputnik-popularity/app/gym"
 	"github.com/alcortesm/sputnik-popularity/app/influx"
 	"github.com/alcortesm/sputnik-popularity/app/recent"
+	"github.com/alcortesm/sputnik-popularity/app/rollup"
+	"github.com/alcortesm/sputnik-popularity/app/schedule"
 	"github.com/alcortesm/sputnik-popularity/app/scrape"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+	"github.com/alcortesm/sputnik-popularity/app/wal"
 	"github.com/alcortesm/sputnik-popularity/app/web"
 	"github.com/alcortesm/sputnik-popularity/pkg/httpdeco"
 )
 
-type config struct {
-	Scrape   scrapeConfig
-	InfluxDB influxConfig
-	Recent   recentConfig
-	Web      webConfig
-	Refresh  refreshConfig
-}
-
-type scrapeConfig struct {
-	URL     string        `required:"true"`
-	GymName string        `required:"true" split_words:"true"`
-	GymID   int           `required:"true" split_words:"true"`
-	Period  time.Duration `default:"10m"`
-	Timeout time.Duration `default:"10s"`
-}
-
-type influxConfig struct {
-	URL         string `required:"true"`
-	TokenWrite  string `required:"true" split_words:"true"`
-	TokenRead   string `required:"true" split_words:"true"`
-	Org         string `default:"tsDemo"`
-	Bucket      string `default:"sputnik_popularity"`
-	Measurement string `default:"capacity_utilization"`
-}
-
-type recentConfig struct {
-	Retention time.Duration `default:"168h" split_words:"true"` // 168h is 1 week
-}
-
-type webConfig struct {
-	Port            int           `default:"8080"`
-	ReadTimeout     time.Duration `default:"10s"`
-	WriteTimeout    time.Duration `default:"10s"`
-	ShutdownTimeout time.Duration `default:"10s"`
-}
-
-type refreshConfig struct {
-	Period time.Duration `default:"1h" split_words:"true"`
-}
+// version is the version of the app, set at build time with
+// -ldflags "-X main.version=...".
+var version = "dev"
 
 func main() {
 	const (
@@ -80,6 +52,10 @@ func main() {
 		if err != nil {
 			logger.Fatalf("%s: processign env vars: %v", failMsg, err)
 		}
+
+		if err := envConfig.validate(); err != nil {
+			logger.Fatalf("%s: invalid config: %v", failMsg, err)
+		}
 	}
 
 	// a context that will canceled by an interrupt signal,
@@ -87,59 +63,193 @@ func main() {
 	signalCtx, cancel := signalContext(syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	// a scraper to gather the data from the gym.
-	var scraper *scrape.Scraper
-	{
-		client := &http.Client{
-			Timeout: envConfig.Scrape.Timeout * time.Second,
+	// the gyms to scrape, with all their config values filled in.
+	gymList, err := envConfig.Scrape.gymList()
+	if err != nil {
+		logger.Fatalf("%s: invalid config: %v", failMsg, err)
+	}
+
+	if envConfig.Scrape.GymName != "" {
+		logger.Println("SPUTNIK_SCRAPE_GYM_NAME and SPUTNIK_SCRAPE_GYM_ID " +
+			"are deprecated, use SPUTNIK_SCRAPE_GYMS instead")
+	}
+
+	gyms := make([]gymConfig, len(gymList))
+	for i, gc := range gymList {
+		gyms[i] = gc.withDefaults(envConfig.Scrape)
+	}
+
+	// run the subcommand in the arguments instead of the app, if any
+	if len(os.Args) > 1 {
+		name := os.Args[1]
+
+		// the standard output is for the data of some commands
+		logger.SetOutput(os.Stderr)
+
+		err := runCommand(signalCtx, logger, envConfig, gyms, name, os.Args[2:])
+		if err != nil {
+			logger.Fatalf("%s: %v", name, err)
 		}
 
-		cfg := scrape.Config{
-			URL:     envConfig.Scrape.URL,
-			GymName: envConfig.Scrape.GymName,
-			GymID:   envConfig.Scrape.GymID,
+		return
+	}
+
+	for _, gc := range gyms {
+		if gc.URL == "" {
+			logger.Fatalf("%s: gym %q: missing URL", failMsg, gc.Name)
 		}
+	}
 
-		scraper = scrape.NewScraper(
-			logger,
-			client,
-			time.Now,
-			cfg,
-		)
+	// a permanent database to store the data from the gyms.
+	db, closeDB, err := newDatabase(logger, envConfig, gyms)
+	if err != nil {
+		logger.Fatalf("%s: creating database: %v", failMsg, err)
 	}
+	defer closeDB()
 
-	// a permanent database to store the data from the gym.
-	var influxStore *influx.Store
+	// queue of scraping attempts, to be stored asynchronously, if the
+	// database supports them.
+	attempts := make(attemptQueue, 100)
+	attemptDB, storesAttempts := db.(attemptStore)
+
+	// a scraper for each gym to gather their data, protected by a
+	// circuit breaker.
+	breakers := make([]*scrape.Breaker, len(gyms))
 	{
-		c := influx.Config(envConfig.InfluxDB)
-		var cancel func()
+		client := &http.Client{
+			Timeout: envConfig.Scrape.Timeout,
+		}
 
-		influxStore, cancel = influx.NewStore(c)
-		defer cancel()
+		for i, gc := range gyms {
+			cfg := scrape.Config{
+				URL:     gc.URL,
+				GymName: gc.Name,
+				GymID:   gc.ID,
+				Retry:   scrape.RetryConfig(envConfig.Scrape.Retry),
+				// nil if all the rules are disabled
+				Validator: envConfig.Scrape.Validation.newValidator(),
+			}
+
+			if storesAttempts {
+				cfg.Tracker = attempts
+			}
+
+			if c := envConfig.Scrape.Archive; c.Dir != "" {
+				dir := filepath.Join(c.Dir, url.PathEscape(gc.Name))
+
+				archive, err := scrape.NewArchive(dir, c.MaxFiles)
+				if err != nil {
+					logger.Fatalf("%s: gym %q: creating archive: %v",
+						failMsg, gc.Name, err)
+				}
+
+				cfg.Recorder = archive
+			}
+
+			source, err := newSource(logger, client, gc, cfg)
+			if err != nil {
+				logger.Fatalf("%s: gym %q: creating source: %v",
+					failMsg, gc.Name, err)
+			}
+
+			breakers[i] = scrape.NewBreaker(
+				logger,
+				time.Now,
+				gc.Name,
+				scrape.BreakerConfig(envConfig.Scrape.Breaker),
+				source,
+			)
+		}
 	}
 
-	// a temporary storage to keep the most recent data from the gym.
-	recentStore, err := recent.NewStore(envConfig.Recent.Retention)
+	// a roller to compute the hourly and daily rollups of the
+	// database, nil if they are disabled.
+	roller, err := newRoller(logger, envConfig, db)
 	if err != nil {
-		logger.Fatalf("%s: creating a recent store: %v", failMsg, err)
+		logger.Fatalf("%s: creating rollups: %v", failMsg, err)
+	}
+
+	// where to add the scraped data: the database or a write-ahead
+	// queue in front of it, so no data is lost during its outages.
+	var dbAdder adder = db
+	var queue *wal.Queue
+	if c := envConfig.WAL; c.Dir != "" {
+		var err error
+
+		queue, err = wal.NewQueue(logger, db, wal.Config(c))
+		if err != nil {
+			logger.Fatalf("%s: creating write-ahead queue: %v",
+				failMsg, err)
+		}
+
+		dbAdder = queue
+	}
+
+	// a temporary storage for each gym to keep their most recent data.
+	recentStores := make(recentByGym, len(gyms))
+	for _, gc := range gyms {
+		var s *recent.Store
+
+		if envConfig.Recent.WallClock {
+			s, err = recent.NewClockStore(envConfig.Recent.Retention, time.Now)
+		} else {
+			s, err = recent.NewStore(envConfig.Recent.Retention)
+		}
+
+		if err != nil {
+			logger.Fatalf("%s: creating a recent store: %v", failMsg, err)
+		}
+
+		recentStores[gc.Name] = s
 	}
 
-	// channel where the scraper sends the scraped data
+	// restore the recent stores from their snapshots, so the web page
+	// has data before the first refresh from the database
+	if dir := envConfig.Recent.SnapshotDir; dir != "" {
+		if p := envConfig.Recent.SnapshotPeriod; p <= 0 {
+			logger.Fatalf("%s: snapshot period must be >0, was %v",
+				failMsg, p)
+		}
+
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			logger.Fatalf("%s: creating the snapshot dir: %v", failMsg, err)
+		}
+
+		recentStores.restore(logger, dir)
+	}
+
+	// channel where the scrapers send the scraped data
 	scrapedCh := make(chan *gym.Utilization)
 
 	// run all tasks within an error group
 	g, ctx := errgroup.WithContext(signalCtx)
 
-	// launch a scraper of gym utilization data
-	g.Go(func() error {
-		return startScraping(
+	// launch a scraper of utilization data for each gym
+	for i := range gyms {
+		name := gyms[i].Name
+		source := breakers[i]
+		gate := schedule.NewGate(
+			gyms[i].Schedule,
+			envConfig.Scrape.ClosedPeriod,
+		)
+		trigger := schedule.NewAdaptive(
 			ctx,
-			logger,
-			scraper,
-			time.Tick(envConfig.Scrape.Period),
-			scrapedCh,
+			envConfig.Scrape.Adaptive.forPeriod(gyms[i].Period),
+			gyms[i].Period,
 		)
-	})
+
+		g.Go(func() error {
+			return startScraping(
+				ctx,
+				logger,
+				name,
+				source,
+				gate,
+				trigger,
+				scrapedCh,
+			)
+		})
+	}
 
 	// launch a processor for the scraped data:
 	// - update the database
@@ -149,29 +259,113 @@ func main() {
 			ctx,
 			logger,
 			scrapedCh,
-			influxStore,
-			recentStore,
+			dbAdder,
+			recentStores,
 		)
 	})
 
+	// launch the forwarding of the write-ahead queue to the database
+	if queue != nil {
+		g.Go(func() error {
+			const prefix = "write-ahead queue"
+
+			logger.Printf("%s: starting with %d values pending...\n",
+				prefix, queue.Depth())
+			defer logger.Printf("%s: stopped\n", prefix)
+
+			if err := queue.Run(ctx); err != nil {
+				return fmt.Errorf("%s: %w", prefix, err)
+			}
+
+			return nil
+		})
+	}
+
+	// launch a processor for the scraping attempts
+	if storesAttempts {
+		g.Go(func() error {
+			return processAttempts(ctx, logger, attempts, attemptDB)
+		})
+	}
+
 	// launch the web server
 	g.Go(func() error {
+		webGyms := make([]web.Gym, len(gyms))
+		for i, gc := range gyms {
+			webGyms[i] = web.Gym{
+				Name:     gc.Name,
+				Recent:   recentStores[gc.Name],
+				Breaker:  breakers[i],
+				Schedule: gc.Schedule,
+			}
+		}
+
 		return launchWebServer(
 			ctx,
 			logger,
 			envConfig.Web,
-			recentStore,
+			webGyms,
+			attemptDB, // nil if the database doesn't store attempts
+			queue,
+			db,
 		)
 	})
 
+	// keep the rollups of the DB up to date
+	if roller != nil {
+		g.Go(func() error {
+			const prefix = "rollups"
+
+			logger.Printf("%s: starting...\n", prefix)
+			defer logger.Printf("%s: stopped\n", prefix)
+
+			if err := roller.Run(ctx); err != nil {
+				return fmt.Errorf("%s: %w", prefix, err)
+			}
+
+			return nil
+		})
+	}
+
+	// forget the values in the recent stores as time passes
+	if envConfig.Recent.WallClock {
+		for name, s := range recentStores {
+			name, s := name, s
+
+			g.Go(func() error {
+				prefix := fmt.Sprintf("recent sweeper for gym %q", name)
+
+				err := s.RunSweeper(ctx, envConfig.Recent.SweepPeriod)
+				if err != nil {
+					return fmt.Errorf("%s: %w", prefix, err)
+				}
+
+				return nil
+			})
+		}
+	}
+
+	// snapshot the recent stores regularly
+	if dir := envConfig.Recent.SnapshotDir; dir != "" {
+		g.Go(func() error {
+			return snapshotRecent(
+				ctx,
+				logger,
+				dir,
+				recentStores,
+				time.Tick(envConfig.Recent.SnapshotPeriod),
+			)
+		})
+	}
+
 	// refresh the recent store from the DB regularly
 	g.Go(func() error {
 		return refreshRecentWithDB(
 			ctx,
 			logger,
 			envConfig.Recent.Retention,
-			influxStore,
-			recentStore,
+			db,
+			recentStores,
 			time.Tick(envConfig.Refresh.Period),
 		)
 	})
@@ -186,6 +380,163 @@ func main() {
 	}
 }
 
+// runCommand runs the subcommand with the given name and arguments.
+func runCommand(
+	ctx context.Context,
+	logger *log.Logger,
+	c config,
+	gyms []gymConfig,
+	name string,
+	args []string,
+) error {
+	switch name {
+	case "import":
+		return runImport(ctx, logger, c, gyms, args)
+	case "export":
+		return runExport(ctx, logger, c, gyms, args)
+	default:
+		return fmt.Errorf("unknown command, want import or export")
+	}
+}
+
+// attemptStore is a database that also stores scraping attempts, like
+// influx.Store.
+type attemptStore interface {
+	attemptAdder
+	web.AttemptGetter
+}
+
+// newDatabase returns the permanent store selected in the config and a
+// function to close it.
+func newDatabase(
+	logger *log.Logger,
+	c config,
+	gyms []gymConfig,
+) (storage.Store, func(), error) {
+	switch c.Storage.Backend {
+	case influxBackend:
+		if err := c.InfluxDB.validate(); err != nil {
+			return nil, nil, fmt.Errorf("influx config: %v", err)
+		}
+
+		ic, err := c.InfluxDB.influxConfig(gyms)
+		if err != nil {
+			return nil, nil, fmt.Errorf("influx config: %v", err)
+		}
+
+		if c.Rollup.Enabled {
+			ic.RollupMeasurements = map[rollup.Tier]string{
+				rollup.Hourly: c.InfluxDB.HourlyMeasurement,
+				rollup.Daily:  c.InfluxDB.DailyMeasurement,
+			}
+		}
+
+		store, cancel := influx.NewStore(ic)
+
+		if c.InfluxDB.MigrateLegacy {
+			go migrateLegacy(logger, store)
+		}
+
+		// errors from batched writes, the channel is nil if batching
+		// is disabled and it is closed when the store is closed
+		if errs := store.Errors(); errs != nil {
+			go func() {
+				for err := range errs {
+					logger.Printf("influx batched write: %v", err)
+				}
+			}()
+		}
+
+		return store, cancel, nil
+	case boltBackend:
+		store, err := boltdb.NewStore(c.Storage.BoltPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		closeFn := func() {
+			if err := store.Close(); err != nil {
+				logger.Printf("closing database: %v", err)
+			}
+		}
+
+		return store, closeFn, nil
+	case fileBackend:
+		fc := c.Storage.File
+
+		store, err := filestore.NewStore(fc.Dir, filestore.Format(fc.Format))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return store, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend %q",
+			c.Storage.Backend)
+	}
+}
+
+// newRoller returns a roller for the rollups of the database, or nil
+// if they are disabled.
+func newRoller(
+	logger *log.Logger,
+	c config,
+	db storage.Store,
+) (*rollup.Roller, error) {
+	if !c.Rollup.Enabled {
+		return nil, nil
+	}
+
+	store, ok := db.(rollup.Store)
+	if !ok {
+		return nil, fmt.Errorf("rollups are not supported by the %s "+
+			"storage backend", c.Storage.Backend)
+	}
+
+	return rollup.NewRoller(logger, db, store, rollup.Config{
+		Interval: c.Rollup.Interval,
+		Lookback: c.Rollup.Lookback,
+		Backfill: c.Rollup.Backfill,
+	}, time.Now)
+}
+
+// migrateLegacy tags the untagged points in the database, see
+// influx.Store.MigrateLegacy.
+func migrateLegacy(logger *log.Logger, store *influx.Store) {
+	const prefix = "migrating legacy points"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	start := time.Unix(0, 0)
+
+	n, err := store.MigrateLegacy(ctx, start, time.Now())
+	if err != nil {
+		logger.Printf("%s: %v", prefix, err)
+		return
+	}
+
+	logger.Printf("%s: %d points tagged", prefix, n)
+}
+
+// newSource returns the scrape source for a gym, according to its
+// config.
+func newSource(
+	logger *log.Logger,
+	client scrape.HTTPer,
+	gc gymConfig,
+	config scrape.Config,
+) (scrape.Source, error) {
+	switch gc.Source {
+	case jsonSource:
+		return scrape.NewJSONSource(logger, client, time.Now, config, gc.JSON)
+	case htmlSource:
+		return scrape.NewHTMLSource(logger, client, time.Now, config, gc.HTML)
+	default:
+		return scrape.NewScraper(logger, client, time.Now, config), nil
+	}
+}
+
 func signalContext(signals ...os.Signal) (
 	context.Context, context.CancelFunc) {
 	ctx := context.Background()
@@ -209,48 +560,68 @@ func signalContext(signals ...os.Signal) (
 func startScraping(
 	ctx context.Context,
 	logger *log.Logger,
-	scraper *scrape.Scraper,
-	trigger <-chan time.Time,
+	gymName string,
+	source scrape.Source,
+	gate *schedule.Gate,
+	trigger trigger,
 	output chan<- *gym.Utilization,
 ) error {
-	const prefix = "scraping"
+	prefix := fmt.Sprintf("scraping %s", gymName)
 
 	logger.Printf("%s: starting...", prefix)
 	defer logger.Printf("%s: stopped", prefix)
 
-	do := func() {
-		u, err := scraper.Scrape(ctx)
+	do := func(now time.Time) {
+		if !gate.Allow(now) {
+			return
+		}
+
+		u, err := source.Scrape(ctx)
+		if errors.Is(err, scrape.ErrOpenCircuit) {
+			// the breaker already logs its state changes
+			return
+		}
+
 		if err != nil {
 			logger.Printf("%s: %v\n", prefix, err)
 			return
 		}
 
+		trigger.Observe(u)
+
 		output <- u
 	}
 
-	do()
+	do(time.Now())
 
 	for {
 		// wait for a trigger or a cancelation of the context
 		select {
-		case _, ok := <-trigger:
+		case now, ok := <-trigger.C():
 			if !ok {
 				return fmt.Errorf("%s: closed trigger channel", prefix)
 			}
+
+			do(now)
 		case <-ctx.Done():
 			return fmt.Errorf("%s: %w", prefix, ctx.Err())
 		}
-
-		do()
 	}
 }
 
+// trigger tells when to scrape and learns from the scraped values to
+// decide when to scrape next, see schedule.Adaptive.
+type trigger interface {
+	C() <-chan time.Time
+	Observe(*gym.Utilization)
+}
+
 func processScrapedData(
 	ctx context.Context,
 	logger *log.Logger,
 	scraped <-chan *gym.Utilization,
-	influxStore *influx.Store,
-	recentStore *recent.Store,
+	db adder,
+	recentStores recentByGym,
 ) error {
 	const prefix = "processing scraped data"
 
@@ -259,14 +630,14 @@ func processScrapedData(
 
 	do := func(u *gym.Utilization) {
 		go func() {
-			if err := influxStore.Add(ctx, u); err != nil {
-				logger.Printf("%s: adding to influx store: %v\n",
+			if err := db.Add(ctx, u); err != nil {
+				logger.Printf("%s: adding to database: %v\n",
 					prefix, err)
 			}
 		}()
 
 		go func() {
-			if err := recentStore.Add(ctx, u); err != nil {
+			if err := recentStores.Add(ctx, u); err != nil {
 				logger.Printf("%s: adding to recent store: %v\n",
 					prefix, err)
 			}
@@ -287,11 +658,60 @@ func processScrapedData(
 	}
 }
 
+// attemptQueue is a scrape.AttemptTracker that queues the attempts so
+// they can be stored asynchronously. Attempts are dropped when the
+// queue is full, so a slow database never slows down the scrapers.
+type attemptQueue chan *scrape.Attempt
+
+// Track implements scrape.AttemptTracker.
+func (q attemptQueue) Track(a *scrape.Attempt) {
+	select {
+	case q <- a:
+	default:
+	}
+}
+
+func processAttempts(
+	ctx context.Context,
+	logger *log.Logger,
+	attempts <-chan *scrape.Attempt,
+	store attemptAdder,
+) error {
+	const prefix = "processing scraping attempts"
+
+	logger.Printf("%s: starting...\n", prefix)
+	defer logger.Printf("%s: stopped\n", prefix)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", prefix, ctx.Err())
+		case a, ok := <-attempts:
+			if !ok {
+				return fmt.Errorf("%s: closed attempts channel", prefix)
+			}
+
+			if err := store.AddAttempts(ctx, a); err != nil {
+				logger.Printf("%s: adding to database: %v\n",
+					prefix, err)
+			}
+		}
+	}
+}
+
+// attemptAdder knows how to store scraping attempts, see influx.Store.
+type attemptAdder interface {
+	AddAttempts(context.Context, ...*scrape.Attempt) error
+}
+
 func launchWebServer(
 	ctx context.Context,
 	logger *log.Logger,
 	config webConfig,
-	recentStore web.Getter,
+	gyms []web.Gym,
+	attempts web.AttemptGetter,
+	queue *wal.Queue,
+	history web.RangeGetter,
 ) error {
 	const prefix = "web server"
 
@@ -299,8 +719,15 @@ func launchWebServer(
 	defer logger.Printf("%s: stopped\n", prefix)
 
 	w := web.Web{
-		Logger: logger,
-		Recent: recentStore,
+		Logger:   logger,
+		Gyms:     gyms,
+		Attempts: attempts,
+		Clock:    time.Now,
+		History:  history,
+	}
+
+	if queue != nil {
+		w.Queue = queue
 	}
 
 	http.Handle("/popularity.html", httpdeco.Decorate(
@@ -308,6 +735,16 @@ func launchWebServer(
 		httpdeco.WithLogs(logger),
 	))
 
+	http.Handle("/status", httpdeco.Decorate(
+		w.StatusHandler(),
+		httpdeco.WithLogs(logger),
+	))
+
+	http.Handle("/health", httpdeco.Decorate(
+		w.HealthHandler(),
+		httpdeco.WithLogs(logger),
+	))
+
 	http.Handle("/chart.js", httpdeco.Decorate(
 		w.ChartHandler(),
 		httpdeco.WithLogs(logger),
@@ -356,7 +793,7 @@ func refreshRecentWithDB(
 	logger *log.Logger,
 	retention time.Duration,
 	store getSincer,
-	recentStore *recent.Store,
+	recentStores recentByGym,
 	trigger <-chan time.Time,
 ) error {
 	const prefix = "recent refresher"
@@ -369,11 +806,11 @@ func refreshRecentWithDB(
 
 		data, err := store.Get(ctx, since)
 		if err != nil {
-			logger.Printf("%s: getting data from influx: %v\n", prefix, err)
+			logger.Printf("%s: getting data from the database: %v\n", prefix, err)
 			return
 		}
 
-		if err := recentStore.Add(ctx, data...); err != nil {
+		if err := recentStores.Add(ctx, data...); err != nil {
 			logger.Printf("%s: adding data to recent: %v\n", prefix, err)
 			return
 		}
@@ -396,8 +833,121 @@ func refreshRecentWithDB(
 	}
 }
 
+// adder knows how to add gym utilization data to a store. See
+// influx.Store and wal.Queue for example.
+type adder interface {
+	Add(context.Context, ...*gym.Utilization) error
+}
+
 // getSincer knows how to retrieve gym utilization data since a certain
 // date. See influx.Store for example.
 type getSincer interface {
 	Get(context.Context, time.Time) ([]*gym.Utilization, error)
 }
+
+// recentByGym holds a recent store for each gym, indexed by gym name.
+type recentByGym map[string]*recent.Store
+
+// Add adds each value to the recent store of its gym. Values from
+// unknown gyms are ignored and reported in the returned error once all
+// the other values have been added.
+func (rr recentByGym) Add(ctx context.Context, data ...*gym.Utilization) error {
+	byGym := make(map[string][]*gym.Utilization, len(rr))
+	unknown := 0
+
+	for _, d := range data {
+		if _, ok := rr[d.Gym]; !ok {
+			unknown++
+			continue
+		}
+
+		byGym[d.Gym] = append(byGym[d.Gym], d)
+	}
+
+	for name, values := range byGym {
+		if err := rr[name].Add(ctx, values...); err != nil {
+			return fmt.Errorf("gym %q: %v", name, err)
+		}
+	}
+
+	if unknown != 0 {
+		return fmt.Errorf("ignored %d values from unknown gyms", unknown)
+	}
+
+	return nil
+}
+
+// snapshotPath returns the path of the snapshot of the recent store of
+// a gym.
+func snapshotPath(dir, name string) string {
+	return filepath.Join(dir, url.PathEscape(name)+".json")
+}
+
+// restore adds the values in the snapshots in dir to the recent store
+// of each gym. Missing snapshots are ignored, corrupt ones are logged
+// and ignored, they will be replaced by the next snapshot.
+func (rr recentByGym) restore(logger *log.Logger, dir string) {
+	const prefix = "recent snapshots"
+
+	for name, s := range rr {
+		err := s.ReadSnapshot(snapshotPath(dir, name))
+
+		switch {
+		case err == nil:
+			logger.Printf("%s: gym %q restored\n", prefix, name)
+		case errors.Is(err, os.ErrNotExist):
+		default:
+			logger.Printf("%s: gym %q: ignoring snapshot: %v\n",
+				prefix, name, err)
+		}
+	}
+}
+
+// snapshot writes a snapshot of the recent store of each gym in dir.
+// It tries all the gyms and returns the first error.
+func (rr recentByGym) snapshot(dir string) error {
+	var first error
+
+	for name, s := range rr {
+		err := s.WriteSnapshot(snapshotPath(dir, name))
+		if err != nil && first == nil {
+			first = fmt.Errorf("gym %q: %v", name, err)
+		}
+	}
+
+	return first
+}
+
+// snapshotRecent writes snapshots of the recent stores in dir on every
+// trigger, and a last one when the context is cancelled.
+func snapshotRecent(
+	ctx context.Context,
+	logger *log.Logger,
+	dir string,
+	recentStores recentByGym,
+	trigger <-chan time.Time,
+) error {
+	const prefix = "recent snapshots"
+
+	logger.Printf("%s: starting...\n", prefix)
+	defer logger.Printf("%s: stopped\n", prefix)
+
+	for {
+		select {
+		case _, ok := <-trigger:
+			if !ok {
+				return fmt.Errorf("%s: closed trigger channel", prefix)
+			}
+		case <-ctx.Done():
+			if err := recentStores.snapshot(dir); err != nil {
+				logger.Printf("%s: %v\n", prefix, err)
+			}
+
+			return fmt.Errorf("%s: %w", prefix, ctx.Err())
+		}
+
+		if err := recentStores.snapshot(dir); err != nil {
+			logger.Printf("%s: %v\n", prefix, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Package codec encodes and decodes gym utilization values as lines of
+// CSV or JSON Lines, the formats of the file store and of the exported
+// data, so both write the same files.
+//
+// CSV files have a header with the column names: gym, timestamp (in
+// RFC 3339 format, in UTC), people, capacity and suspicious. JSON Lines
+// files have an object per line with the same keys, suspicious is only
+// present for suspicious values.
+package codec
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Format is a line format.
+type Format string
+
+// The supported formats.
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+)
+
+// Codec knows how to encode and decode the lines of a format.
+type Codec interface {
+	// Ext is the extension of the files of the format, like ".csv".
+	Ext() string
+	// Header is the first line of the files, nil for no header.
+	Header() []byte
+	// Encode returns the line of a value, with its line break.
+	Encode(*gym.Utilization) ([]byte, error)
+	// Decode returns the value of a line, without its line break.
+	Decode(line []byte) (*gym.Utilization, error)
+}
+
+// New returns the codec of a format.
+func New(format Format) (Codec, error) {
+	switch format {
+	case CSV:
+		return csvCodec{}, nil
+	case JSONL:
+		return jsonlCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// csvCodec is the codec of CSV files.
+type csvCodec struct{}
+
+func (csvCodec) Ext() string { return ".csv" }
+
+func (csvCodec) Header() []byte {
+	return []byte("gym,timestamp,people,capacity,suspicious\n")
+}
+
+func (csvCodec) Encode(u *gym.Utilization) ([]byte, error) {
+	if strings.ContainsAny(u.Gym, "\r\n") {
+		return nil, fmt.Errorf("gym name %q has line breaks", u.Gym)
+	}
+
+	var b bytes.Buffer
+
+	w := csv.NewWriter(&b)
+
+	err := w.Write([]string{
+		u.Gym,
+		u.Timestamp.UTC().Format(time.RFC3339Nano),
+		strconv.FormatUint(u.People, 10),
+		strconv.FormatUint(u.Capacity, 10),
+		strconv.FormatBool(u.Suspicious),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.Flush()
+
+	return b.Bytes(), w.Error()
+}
+
+func (csvCodec) Decode(line []byte) (*gym.Utilization, error) {
+	r := csv.NewReader(bytes.NewReader(line))
+	r.FieldsPerRecord = 5
+
+	fields, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty line")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("parsing timestamp: %v", err)
+	}
+
+	people, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing people: %v", err)
+	}
+
+	capacity, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing capacity: %v", err)
+	}
+
+	suspicious, err := strconv.ParseBool(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("parsing suspicious: %v", err)
+	}
+
+	return &gym.Utilization{
+		Gym:        fields[0],
+		Timestamp:  ts,
+		People:     people,
+		Capacity:   capacity,
+		Suspicious: suspicious,
+	}, nil
+}
+
+// jsonlCodec is the codec of JSON Lines files.
+type jsonlCodec struct{}
+
+// jsonlLine is the content of each line of JSON Lines files.
+type jsonlLine struct {
+	Gym        string    `json:"gym"`
+	Timestamp  time.Time `json:"timestamp"`
+	People     uint64    `json:"people"`
+	Capacity   uint64    `json:"capacity"`
+	Suspicious bool      `json:"suspicious,omitempty"`
+}
+
+func (jsonlCodec) Ext() string { return ".jsonl" }
+
+func (jsonlCodec) Header() []byte { return nil }
+
+func (jsonlCodec) Encode(u *gym.Utilization) ([]byte, error) {
+	b, err := json.Marshal(jsonlLine{
+		Gym:        u.Gym,
+		Timestamp:  u.Timestamp.UTC(),
+		People:     u.People,
+		Capacity:   u.Capacity,
+		Suspicious: u.Suspicious,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+func (jsonlCodec) Decode(line []byte) (*gym.Utilization, error) {
+	var l jsonlLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		return nil, err
+	}
+
+	return &gym.Utilization{
+		Gym:        l.Gym,
+		Timestamp:  l.Timestamp,
+		People:     l.People,
+		Capacity:   l.Capacity,
+		Suspicious: l.Suspicious,
+	}, nil
+}
@@ -0,0 +1,119 @@
+package codec_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/codec"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+func TestCodec(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2020, time.October, 1, 18, 0, 0, 5, time.UTC)
+
+	data := []*gym.Utilization{
+		{Gym: "a", Timestamp: ts, People: 1, Capacity: 10},
+		{Gym: "a,b \"c\"", Timestamp: ts, People: 2, Capacity: 10, Suspicious: true},
+	}
+
+	subtests := map[codec.Format]struct {
+		wantExt    string
+		wantHeader string
+		wantLines  []string
+	}{
+		codec.CSV: {
+			wantExt:    ".csv",
+			wantHeader: "gym,timestamp,people,capacity,suspicious\n",
+			wantLines: []string{
+				"a,2020-10-01T18:00:00.000000005Z,1,10,false\n",
+				"\"a,b \"\"c\"\"\",2020-10-01T18:00:00.000000005Z,2,10,true\n",
+			},
+		},
+		codec.JSONL: {
+			wantExt: ".jsonl",
+			wantLines: []string{
+				`{"gym":"a","timestamp":"2020-10-01T18:00:00.000000005Z",` +
+					`"people":1,"capacity":10}` + "\n",
+				`{"gym":"a,b \"c\"","timestamp":"2020-10-01T18:00:00.000000005Z",` +
+					`"people":2,"capacity":10,"suspicious":true}` + "\n",
+			},
+		},
+	}
+
+	for format, test := range subtests {
+		format, test := format, test
+
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			c, err := codec.New(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.Ext(); got != test.wantExt {
+				t.Errorf("ext: want %q, got %q", test.wantExt, got)
+			}
+
+			if got := string(c.Header()); got != test.wantHeader {
+				t.Errorf("header: want %q, got %q", test.wantHeader, got)
+			}
+
+			for i, d := range data {
+				line, err := c.Encode(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := string(line); got != test.wantLines[i] {
+					t.Errorf("line %d: want %q, got %q",
+						i, test.wantLines[i], got)
+				}
+
+				got, err := c.Decode(bytes.TrimSuffix(line, []byte("\n")))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(d, got); diff != "" {
+					t.Errorf("line %d: (-want +got)\n%s", i, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestCodec_Errors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := codec.New("xlsx"); err == nil {
+		t.Error("unknown format: unexpected success")
+	}
+
+	c, err := codec.New(codec.CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broken := &gym.Utilization{Gym: "a\nb", Capacity: 1}
+
+	if _, err := c.Encode(broken); err == nil {
+		t.Error("gym with line breaks: unexpected success")
+	}
+
+	for _, line := range []string{
+		"",
+		"a,2020-10-01T18:00:00Z,1,10",
+		"a,yesterday,1,10,false",
+		"a,2020-10-01T18:00:00Z,-1,10,false",
+	} {
+		if _, err := c.Decode([]byte(line)); err == nil {
+			t.Errorf("decoding %q: unexpected success", line)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package export writes gym utilization data in formats other tools
+// can read, to get it out of the stores.
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alcortesm/sputnik-popularity/app/codec"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/influx"
+)
+
+// Format is the format of exported data.
+type Format string
+
+// The supported formats.
+const (
+	// CSV and JSONL are encoded like the files of filestore.Store, see
+	// package codec.
+	CSV   = Format(codec.CSV)
+	JSONL = Format(codec.JSONL)
+	// Line is the InfluxDB line protocol, with the gym and schema tags
+	// of the points written by influx.Store, so loading them into the
+	// app's measurement overwrites its points instead of duplicating
+	// them, and the people, capacity and suspicious (only if true)
+	// fields. The version, gym ID and source fields are not exported.
+	Line Format = "line"
+)
+
+// Writer writes gym utilization data in a format.
+type Writer struct {
+	w           *bufio.Writer
+	format      Format
+	measurement string
+	codec       codec.Codec // nil for the line protocol
+	wroteHeader bool
+}
+
+// NewWriter returns a writer of the given format. The measurement is
+// only used by the line protocol format.
+func NewWriter(w io.Writer, format Format, measurement string) (*Writer, error) {
+	result := &Writer{
+		w:           bufio.NewWriter(w),
+		format:      format,
+		measurement: measurement,
+	}
+
+	if format == Line {
+		if measurement == "" {
+			return nil, fmt.Errorf("missing measurement")
+		}
+
+		if strings.ContainsAny(measurement, "\r\n") {
+			return nil, fmt.Errorf("measurement %q has line breaks",
+				measurement)
+		}
+
+		return result, nil
+	}
+
+	c, err := codec.New(codec.Format(format))
+	if err != nil {
+		return nil, err
+	}
+
+	result.codec = c
+
+	return result, nil
+}
+
+// Write writes the values, call Flush after the last call to Write.
+func (w *Writer) Write(data ...*gym.Utilization) error {
+	for _, d := range data {
+		var err error
+
+		if w.codec != nil {
+			err = w.writeCodec(d)
+		} else {
+			err = w.writeLine(d)
+		}
+
+		if err != nil {
+			return fmt.Errorf("writing %v: %v", d, err)
+		}
+	}
+
+	return nil
+}
+
+// Flush writes any buffered data, and the header of the format, like
+// the CSV one, if nothing was written yet.
+func (w *Writer) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	return w.w.Flush()
+}
+
+// WriteHeader writes the header of the format, if it has one and it
+// has not been written yet.
+func (w *Writer) writeHeader() error {
+	if w.codec == nil || w.wroteHeader {
+		return nil
+	}
+
+	w.wroteHeader = true
+
+	_, err := w.w.Write(w.codec.Header())
+
+	return err
+}
+
+func (w *Writer) writeCodec(u *gym.Utilization) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	b, err := w.codec.Encode(u)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(b)
+
+	return err
+}
+
+// The escaping of measurements and tags in the line protocol, which
+// has no escaping for line breaks.
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	tagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+func (w *Writer) writeLine(u *gym.Utilization) error {
+	if strings.ContainsAny(u.Gym, "\r\n") {
+		return fmt.Errorf("gym name %q has line breaks", u.Gym)
+	}
+
+	var b strings.Builder
+
+	b.WriteString(measurementEscaper.Replace(w.measurement))
+
+	// empty tag values are not allowed, and the untagged legacy points
+	// have no schema either
+	if u.Gym != "" {
+		fmt.Fprintf(&b, ",%s=%s,%s=%s",
+			influx.GymTagKey, tagEscaper.Replace(u.Gym),
+			influx.SchemaTagKey, influx.Schema)
+	}
+
+	fmt.Fprintf(&b, " people=%du,capacity=%du", u.People, u.Capacity)
+
+	if u.Suspicious {
+		b.WriteString(",suspicious=true")
+	}
+
+	fmt.Fprintf(&b, " %d\n", u.Timestamp.UnixNano())
+
+	_, err := w.w.WriteString(b.String())
+
+	return err
+}
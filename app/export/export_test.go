@@ -0,0 +1,118 @@
+package export_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/export"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+	data := []*gym.Utilization{
+		{Gym: "a", Timestamp: t0, People: 1, Capacity: 10},
+		{Gym: "b c,d=e", Timestamp: t0.Add(time.Minute), People: 20, Capacity: 10, Suspicious: true},
+	}
+
+	subtests := map[string]struct {
+		format export.Format
+		data   []*gym.Utilization
+		want   string
+	}{
+		"csv": {
+			format: export.CSV,
+			data:   data,
+			want: "gym,timestamp,people,capacity,suspicious\n" +
+				"a,2020-10-01T18:00:00Z,1,10,false\n" +
+				"\"b c,d=e\",2020-10-01T18:01:00Z,20,10,true\n",
+		},
+		"empty csv": {
+			format: export.CSV,
+			data:   nil,
+			want:   "gym,timestamp,people,capacity,suspicious\n",
+		},
+		"jsonl": {
+			format: export.JSONL,
+			data:   data,
+			want: `{"gym":"a","timestamp":"2020-10-01T18:00:00Z","people":1,"capacity":10}` + "\n" +
+				`{"gym":"b c,d=e","timestamp":"2020-10-01T18:01:00Z","people":20,"capacity":10,"suspicious":true}` + "\n",
+		},
+		"line protocol": {
+			format: export.Line,
+			data:   data,
+			want: "my\\ measurement,gym=a,schema=3 people=1u,capacity=10u 1601575200000000000\n" +
+				"my\\ measurement,gym=b\\ c\\,d\\=e,schema=3 people=20u,capacity=10u,suspicious=true 1601575260000000000\n",
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var b bytes.Buffer
+
+			w, err := export.NewWriter(&b, test.format, "my measurement")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.Write(test.data...); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.want, b.String()); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewWriter_Errors(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+
+	if _, err := export.NewWriter(&b, "xml", "m"); err == nil {
+		t.Error("unknown format: unexpected success")
+	}
+
+	if _, err := export.NewWriter(&b, export.Line, ""); err == nil {
+		t.Error("line protocol without measurement: unexpected success")
+	}
+
+	if _, err := export.NewWriter(&b, export.Line, "a\nb"); err == nil {
+		t.Error("measurement with line breaks: unexpected success")
+	}
+}
+
+func TestWriter_LineBreaks(t *testing.T) {
+	t.Parallel()
+
+	broken := &gym.Utilization{Gym: "a\nb", Timestamp: time.Unix(0, 0), Capacity: 1}
+
+	for _, format := range []export.Format{export.CSV, export.Line} {
+		var b bytes.Buffer
+
+		w, err := export.NewWriter(&b, format, "m")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Write(broken); err == nil {
+			t.Errorf("%s: unexpected success", format)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package filestore implements an append-only store of gym utilization
+// data in daily files, in CSV or JSON Lines format, for hobby setups
+// and for archiving.
+package filestore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/codec"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Format is the format of the files of a store.
+type Format string
+
+// The supported formats, see package codec.
+const (
+	CSV   = Format(codec.CSV)
+	JSONL = Format(codec.JSONL)
+)
+
+// dayLayout is the layout of the file names, without their extension.
+const dayLayout = "2006-01-02"
+
+// Store is a storage.Store that appends each value to a file for the
+// day of its timestamp, in UTC, like 2020-10-01.csv. Files are never
+// rewritten, so they are easy to archive, and reads only open the
+// files of the requested days.
+//
+// Overwriting a value appends the new one, the last value for each gym
+// and timestamp is the one returned by Get and GetRange.
+//
+// The lines of the files are encoded like the exported data, see
+// package codec.
+type Store struct {
+	dir   string
+	codec codec.Codec
+
+	mux sync.Mutex // serializes writes
+}
+
+// NewStore returns a store for the files in the given directory, which
+// is created if it doesn't exist.
+func NewStore(dir string, format Format) (*Store, error) {
+	c, err := codec.New(codec.Format(format))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating directory: %v", err)
+	}
+
+	return &Store{
+		dir:   dir,
+		codec: c,
+	}, nil
+}
+
+// Add implements storage.Store.
+func (s *Store) Add(_ context.Context, data ...*gym.Utilization) error {
+	byDay := map[string][]byte{}
+	days := []string{}
+
+	for _, d := range data {
+		line, err := s.codec.Encode(d)
+		if err != nil {
+			return fmt.Errorf("encoding %v: %v", d, err)
+		}
+
+		day := d.Timestamp.UTC().Format(dayLayout)
+		if _, ok := byDay[day]; !ok {
+			days = append(days, day)
+		}
+
+		byDay[day] = append(byDay[day], line...)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, day := range days {
+		if err := s.append(s.path(day), byDay[day]); err != nil {
+			return fmt.Errorf("appending to %s file: %v", day, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) path(day string) string {
+	return filepath.Join(s.dir, day+s.codec.Ext())
+}
+
+// Append appends the lines to a file, creating it with a header if it
+// doesn't exist. If the file ends with an incomplete line, from a
+// write interrupted by a crash, it is removed first so the new lines
+// are not mixed with it.
+func (s *Store) append(path string, lines []byte) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := s.prepare(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err := f.Write(lines); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Prepare makes a file ready to append lines to it: it writes the
+// header to new files and removes the incomplete last line of existing
+// ones, if any.
+func (s *Store) prepare(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	if size == 0 {
+		_, err := f.Write(s.codec.Header())
+		return err
+	}
+
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return err
+	}
+
+	if last[0] == '\n' {
+		return nil
+	}
+
+	// this only happens after a crash, so reading the whole file is
+	// fine
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return err
+	}
+
+	return f.Truncate(int64(bytes.LastIndexByte(b, '\n') + 1))
+}
+
+// Get implements storage.Store.
+func (s *Store) Get(
+	ctx context.Context,
+	since time.Time,
+) ([]*gym.Utilization, error) {
+	return s.GetRange(ctx, since, time.Time{})
+}
+
+// GetRange implements storage.Store. A zero end means no end.
+func (s *Store) GetRange(
+	_ context.Context,
+	start, end time.Time,
+) ([]*gym.Utilization, error) {
+	days, err := s.days(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		gym string
+		ts  int64
+	}
+
+	// the index of each gym and timestamp in the result, to
+	// overwrite them with the values added later
+	index := map[key]int{}
+	result := []*gym.Utilization{}
+
+	for _, day := range days {
+		values, err := s.read(s.path(day))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s file: %v", day, err)
+		}
+
+		for _, v := range values {
+			if v.Timestamp.Before(start) ||
+				(!end.IsZero() && !v.Timestamp.Before(end)) {
+				continue
+			}
+
+			k := key{gym: v.Gym, ts: v.Timestamp.UnixNano()}
+			if i, ok := index[k]; ok {
+				result[i] = v
+				continue
+			}
+
+			index[k] = len(result)
+			result = append(result, v)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Gym != result[j].Gym {
+			return result[i].Gym < result[j].Gym
+		}
+
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+
+	return result, nil
+}
+
+// Days returns the days with files that may have values between start
+// and end, sorted chronologically. A zero end means no end.
+func (s *Store) days(start, end time.Time) ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing files: %v", err)
+	}
+
+	first := start.UTC().Format(dayLayout)
+	last := ""
+
+	if !end.IsZero() {
+		last = end.Add(-time.Nanosecond).UTC().Format(dayLayout)
+	}
+
+	result := []string{}
+
+	for _, info := range infos {
+		name := info.Name()
+
+		if info.IsDir() || filepath.Ext(name) != s.codec.Ext() {
+			continue
+		}
+
+		day := strings.TrimSuffix(name, s.codec.Ext())
+		if _, err := time.Parse(dayLayout, day); err != nil {
+			continue
+		}
+
+		// the layout sorts lexicographically in chronological order
+		if day < first || (last != "" && day > last) {
+			continue
+		}
+
+		result = append(result, day)
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// Read returns the values in a file, in the order they were added. An
+// incomplete last line, from a write interrupted by a crash, is
+// ignored.
+func (s *Store) read(path string) ([]*gym.Utilization, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if i := bytes.LastIndexByte(b, '\n'); i != len(b)-1 {
+		b = b[:i+1]
+	}
+
+	lines := bytes.Split(b, []byte("\n"))
+	result := make([]*gym.Utilization, 0, len(lines))
+	header := bytes.TrimSuffix(s.codec.Header(), []byte("\n"))
+
+	for i, line := range lines {
+		if len(line) == 0 || (i == 0 && bytes.Equal(line, header)) {
+			continue
+		}
+
+		u, err := s.codec.Decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+
+		result = append(result, u)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,229 @@
+package filestore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/filestore"
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// the file store must be usable as a storage.Store.
+var _ storage.Store = &filestore.Store{}
+
+var formats = []filestore.Format{filestore.CSV, filestore.JSONL}
+
+// day1 is 2020-10-01 at midnight.
+var day1 = time.Date(2020, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+func at(hours int) time.Time {
+	return day1.Add(time.Duration(hours) * time.Hour)
+}
+
+func TestStore_GetRange(t *testing.T) {
+	t.Parallel()
+
+	data := []*gym.Utilization{
+		{Gym: "b", Timestamp: at(26), People: 20, Capacity: 100},
+		{Gym: "a", Timestamp: at(3), People: 3, Capacity: 10, Suspicious: true},
+		{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10},
+		{Gym: "a,b \"c\"", Timestamp: at(2), People: 2, Capacity: 10},
+		{Gym: "a", Timestamp: at(50), People: 5, Capacity: 10},
+	}
+
+	subtests := map[string]struct {
+		start, end time.Time
+		want       []*gym.Utilization
+	}{
+		"everything": {
+			start: at(-100),
+			end:   at(100),
+			want: []*gym.Utilization{
+				data[2], data[1], data[4], data[3], data[0],
+			},
+		},
+		"start is inclusive, end is exclusive": {
+			start: at(3),
+			end:   at(50),
+			want:  []*gym.Utilization{data[1], data[0]},
+		},
+		"no end": {
+			start: at(26),
+			want:  []*gym.Utilization{data[4], data[0]},
+		},
+		"end at midnight": {
+			start: at(0),
+			end:   at(24),
+			want:  []*gym.Utilization{data[2], data[1], data[3]},
+		},
+	}
+
+	for _, format := range formats {
+		format := format
+
+		store, err := filestore.NewStore(t.TempDir(), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Add(context.Background(), data...); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, test := range subtests {
+			test := test
+
+			t.Run(string(format)+"/"+name, func(t *testing.T) {
+				t.Parallel()
+
+				got, err := store.GetRange(
+					context.Background(), test.start, test.end)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(test.want, got); diff != "" {
+					t.Errorf("(-want +got)\n%s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestStore_Overwrite(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range formats {
+		format := format
+
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			store, err := filestore.NewStore(t.TempDir(), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+
+			old := &gym.Utilization{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10}
+			newer := &gym.Utilization{Gym: "a", Timestamp: at(1), People: 2, Capacity: 10}
+
+			if err := store.Add(ctx, old); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.Add(ctx, newer); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.Get(ctx, day1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []*gym.Utilization{newer}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStore_Files(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	store, err := filestore.NewStore(dir, filestore.CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10},
+		{Gym: "a", Timestamp: at(25), People: 2, Capacity: 10},
+	}
+
+	if err := store.Add(context.Background(), data...); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"2020-10-01.csv": "gym,timestamp,people,capacity,suspicious\n" +
+			"a,2020-10-01T01:00:00Z,1,10,false\n",
+		"2020-10-02.csv": "gym,timestamp,people,capacity,suspicious\n" +
+			"a,2020-10-02T01:00:00Z,2,10,false\n",
+	}
+
+	for name, content := range want {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(content, string(b)); diff != "" {
+			t.Errorf("%s: (-want +got)\n%s", name, diff)
+		}
+	}
+}
+
+func TestStore_IncompleteLine(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// a file with a line half written before a crash
+	path := filepath.Join(dir, "2020-10-01.jsonl")
+	content := `{"gym":"a","timestamp":"2020-10-01T01:00:00Z","people":1,"capacity":10}` +
+		"\n" + `{"gym":"a","timesta`
+
+	if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := filestore.NewStore(dir, filestore.JSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// the incomplete line is ignored when reading...
+	got, err := store.Get(ctx, day1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 {
+		t.Errorf("want 1 value, got %v", got)
+	}
+
+	// ...and removed when appending
+	added := &gym.Utilization{Gym: "a", Timestamp: at(2), People: 2, Capacity: 10}
+
+	if err := store.Add(ctx, added); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = store.Get(ctx, day1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10},
+		added,
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
@@ -5,15 +5,20 @@ import (
 	"time"
 )
 
+// Utilization is how busy a gym is at a given moment.
 type Utilization struct {
+	Gym       string // identifies the gym
 	Timestamp time.Time
 	People    uint64
 	Capacity  uint64
+	// Suspicious values passed validation but look wrong, they are
+	// kept but flagged.
+	Suspicious bool
 }
 
 func (u *Utilization) String() string {
-	return fmt.Sprintf("(%s, %d, %d)",
-		u.Timestamp.Format(time.RFC3339), u.People, u.Capacity)
+	return fmt.Sprintf("(%s, %s, %d, %d)",
+		u.Gym, u.Timestamp.Format(time.RFC3339), u.People, u.Capacity)
 }
 
 func (u *Utilization) Percent() (float64, bool) {
@@ -25,6 +30,10 @@ func (u *Utilization) Percent() (float64, bool) {
 }
 
 func (u *Utilization) Equal(o *Utilization) bool {
+	if u.Gym != o.Gym {
+		return false
+	}
+
 	if !u.Timestamp.Equal(o.Timestamp) {
 		return false
 	}
@@ -37,5 +46,9 @@ func (u *Utilization) Equal(o *Utilization) bool {
 		return false
 	}
 
+	if u.Suspicious != o.Suspicious {
+		return false
+	}
+
 	return true
 }
@@ -81,8 +81,8 @@ func TestUtilization_Equal(t *testing.T) {
 		},
 		{
 			name: "same data",
-			a:    &gym.Utilization{Timestamp: t1, People: 1, Capacity: 2},
-			b:    &gym.Utilization{Timestamp: t1, People: 1, Capacity: 2},
+			a:    &gym.Utilization{Gym: "a", Timestamp: t1, People: 1, Capacity: 2},
+			b:    &gym.Utilization{Gym: "a", Timestamp: t1, People: 1, Capacity: 2},
 			want: true,
 		},
 		{
@@ -91,6 +91,12 @@ func TestUtilization_Equal(t *testing.T) {
 			b:    &gym.Utilization{Timestamp: t1NY, People: 1, Capacity: 2},
 			want: true,
 		},
+		{
+			name: "different gyms",
+			a:    &gym.Utilization{Gym: "a"},
+			b:    &gym.Utilization{Gym: "b"},
+			want: false,
+		},
 		{
 			name: "different times",
 			a:    &gym.Utilization{Timestamp: t1},
@@ -109,6 +115,12 @@ func TestUtilization_Equal(t *testing.T) {
 			b:    &gym.Utilization{Capacity: 2},
 			want: false,
 		},
+		{
+			name: "different suspicion",
+			a:    &gym.Utilization{Suspicious: true},
+			b:    &gym.Utilization{Suspicious: false},
+			want: false,
+		},
 	}
 
 	for _, test := range subtests {
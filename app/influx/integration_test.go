@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"testing"
 	"time"
 
@@ -16,6 +17,16 @@ import (
 
 	"github.com/alcortesm/sputnik-popularity/app/gym"
 	"github.com/alcortesm/sputnik-popularity/app/influx"
+	"github.com/alcortesm/sputnik-popularity/app/rollup"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// the influx store must be usable as a storage.Aggregator and a
+// rollup.Store.
+var (
+	_ storage.Aggregator = &influx.Store{}
+	_ rollup.Store       = &influx.Store{}
 )
 
 const (
@@ -154,9 +165,9 @@ func TestInflux_AddGet(t *testing.T) {
 	t3 := fix.start.Add(3 * time.Second)
 
 	data := []*gym.Utilization{
-		{Timestamp: t1, People: 1, Capacity: 42},
-		{Timestamp: t2, People: 2, Capacity: 42},
-		{Timestamp: t3, People: 3, Capacity: 42},
+		{Gym: "a", Timestamp: t1, People: 1, Capacity: 42},
+		{Gym: "a", Timestamp: t2, People: 2, Capacity: 42, Suspicious: true},
+		{Gym: "a", Timestamp: t3, People: 3, Capacity: 42},
 	}
 
 	// Add some utilization data to the DB
@@ -209,3 +220,526 @@ func TestInflux_AddGet(t *testing.T) {
 		t.Errorf("(-want +got)\n%s", diff)
 	}
 }
+
+func TestInflux_AddGetAttempts(t *testing.T) {
+	t.Parallel()
+
+	fix := struct {
+		measurement string
+		start       time.Time
+		timeout     time.Duration
+	}{
+		measurement: "m_" + t.Name(),
+		start:       year2020,
+		timeout:     10 * time.Second,
+	}
+
+	store, cancel := influx.NewStore(
+		influx.Config{
+			URL:                 dbURL,
+			Org:                 org,
+			TokenWrite:          token,
+			TokenRead:           token,
+			Bucket:              bucket,
+			AttemptsMeasurement: fix.measurement,
+		},
+	)
+	t.Cleanup(cancel)
+
+	data := []*scrape.Attempt{
+		{
+			Gym:       "a",
+			Timestamp: fix.start.Add(1 * time.Second),
+			Number:    1,
+			Latency:   1500 * time.Millisecond,
+			Status:    503,
+			Error:     scrape.ErrorStatus,
+		},
+		{
+			Gym:       "a",
+			Timestamp: fix.start.Add(3 * time.Second),
+			Number:    2,
+			Latency:   250 * time.Millisecond,
+			Status:    200,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fix.timeout)
+	t.Cleanup(cancel)
+
+	if err := store.AddAttempts(ctx, data...); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetAttempts(ctx, fix.start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(data, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestInflux_Fields(t *testing.T) {
+	t.Parallel()
+
+	fix := struct {
+		measurement string
+		start       time.Time
+		timeout     time.Duration
+	}{
+		measurement: "m_" + t.Name(),
+		start:       year2020,
+		timeout:     10 * time.Second,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fix.timeout)
+	t.Cleanup(cancel)
+
+	config := influx.Config{
+		URL:                 dbURL,
+		Org:                 org,
+		TokenWrite:          token,
+		TokenRead:           token,
+		Bucket:              bucket,
+		Measurement:         fix.measurement,
+		AttemptsMeasurement: fix.measurement + "_attempts",
+		GymFields: map[string]map[string]string{
+			"a": {influx.GymIDFieldKey: "7"},
+			"b": {influx.GymIDFieldKey: "8"},
+		},
+	}
+
+	data := []*gym.Utilization{
+		{Gym: "a", Timestamp: fix.start.Add(time.Second), People: 1, Capacity: 10},
+		{Gym: "b", Timestamp: fix.start.Add(time.Second), People: 2, Capacity: 20},
+	}
+
+	attempts := []*scrape.Attempt{
+		{Gym: "a", Timestamp: fix.start.Add(time.Second), Number: 1, Status: 200},
+		{Gym: "b", Timestamp: fix.start.Add(time.Second), Number: 1, Status: 200},
+	}
+
+	// the same values written by different versions of the app
+	for _, version := range []string{"1", "2"} {
+		config.Fields = map[string]string{influx.VersionFieldKey: version}
+
+		store, cancel := influx.NewStore(config)
+		t.Cleanup(cancel)
+
+		if err := store.Add(ctx, data...); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.AddAttempts(ctx, attempts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subtests := map[string]struct {
+		filter       map[string]string
+		wantData     []*gym.Utilization
+		wantAttempts []*scrape.Attempt
+	}{
+		"no filter": {
+			wantData:     data,
+			wantAttempts: attempts,
+		},
+		"by gym": {
+			filter:       map[string]string{influx.GymIDFieldKey: "8"},
+			wantData:     data[1:],
+			wantAttempts: attempts[1:],
+		},
+		"overwritten version": {
+			filter:       map[string]string{influx.VersionFieldKey: "1"},
+			wantData:     []*gym.Utilization{},
+			wantAttempts: []*scrape.Attempt{},
+		},
+	}
+
+	for name, test := range subtests {
+		name, test := name, test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			config := config
+			config.Filter = test.filter
+
+			store, cancel := influx.NewStore(config)
+			t.Cleanup(cancel)
+
+			got, err := store.Get(ctx, fix.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.SliceStable(got, func(i, j int) bool {
+				return got[i].Gym < got[j].Gym
+			})
+
+			if diff := cmp.Diff(test.wantData, got); diff != "" {
+				t.Errorf("data (-want +got)\n%s", diff)
+			}
+
+			gotAttempts, err := store.GetAttempts(ctx, fix.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.SliceStable(gotAttempts, func(i, j int) bool {
+				return gotAttempts[i].Gym < gotAttempts[j].Gym
+			})
+
+			if diff := cmp.Diff(test.wantAttempts, gotAttempts); diff != "" {
+				t.Errorf("attempts (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInflux_BatchedAdd(t *testing.T) {
+	t.Parallel()
+
+	fix := struct {
+		measurement string
+		start       time.Time
+		timeout     time.Duration
+	}{
+		measurement: "m_" + t.Name(),
+		start:       year2020,
+		timeout:     10 * time.Second,
+	}
+
+	store, cancel := influx.NewStore(
+		influx.Config{
+			URL:           dbURL,
+			Org:           org,
+			TokenWrite:    token,
+			TokenRead:     token,
+			Bucket:        bucket,
+			Measurement:   fix.measurement,
+			BatchSize:     100,
+			FlushInterval: time.Hour,
+		},
+	)
+	t.Cleanup(cancel)
+
+	data := []*gym.Utilization{
+		{Gym: "a", Timestamp: fix.start.Add(1 * time.Second), People: 1, Capacity: 42},
+		{Gym: "a", Timestamp: fix.start.Add(2 * time.Second), People: 2, Capacity: 42},
+	}
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), fix.timeout)
+	t.Cleanup(cancelCtx)
+
+	if err := store.Add(ctx, data...); err != nil {
+		t.Fatal(err)
+	}
+
+	// the batch is neither full nor old enough to be written yet
+	store.Flush()
+
+	select {
+	case err := <-store.Errors():
+		t.Fatal(err)
+	default:
+	}
+
+	got, err := store.Get(ctx, fix.start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(data, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestInflux_LegacyPoints(t *testing.T) {
+	t.Parallel()
+
+	fix := struct {
+		measurement string
+		start       time.Time
+		timeout     time.Duration
+	}{
+		measurement: "m_" + t.Name(),
+		start:       year2020,
+		timeout:     10 * time.Second,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fix.timeout)
+	t.Cleanup(cancel)
+
+	ts := fix.start.Add(time.Second)
+
+	// an untagged point, like the ones from before the app supported
+	// several gyms
+	{
+		client := influxdb2.NewClient(dbURL, token)
+		t.Cleanup(client.Close)
+
+		p := influxdb2.NewPoint(
+			fix.measurement,
+			map[string]string{},
+			map[string]interface{}{"people": uint64(1), "capacity": uint64(42)},
+			ts,
+		)
+
+		err := client.WriteAPIBlocking(org, bucket).WritePoint(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := influx.Config{
+		URL:         dbURL,
+		Org:         org,
+		TokenWrite:  token,
+		TokenRead:   token,
+		Bucket:      bucket,
+		Measurement: fix.measurement,
+		GymFields: map[string]map[string]string{
+			"a": {influx.GymIDFieldKey: "7"},
+		},
+		LegacyGym: "a",
+	}
+
+	store, cancel := influx.NewStore(config)
+	t.Cleanup(cancel)
+
+	want := []*gym.Utilization{
+		{Gym: "a", Timestamp: ts, People: 1, Capacity: 42},
+	}
+
+	// legacy points belong to the legacy gym
+	got, err := store.Get(ctx, fix.start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("before migration: (-want +got)\n%s", diff)
+	}
+
+	n, err := store.MigrateLegacy(ctx, fix.start, fix.start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Errorf("want 1 migrated point, got %d", n)
+	}
+
+	// migrated points are not returned twice
+	got, err = store.Get(ctx, fix.start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("after migration: (-want +got)\n%s", diff)
+	}
+
+	// migrated points have the fields of the legacy gym
+	config.Filter = map[string]string{influx.GymIDFieldKey: "7"}
+
+	filtered, cancel := influx.NewStore(config)
+	t.Cleanup(cancel)
+
+	got, err = filtered.Get(ctx, fix.start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("filtered: (-want +got)\n%s", diff)
+	}
+}
+
+func TestInflux_GetAggregated(t *testing.T) {
+	t.Parallel()
+
+	fix := struct {
+		measurement string
+		start       time.Time
+		timeout     time.Duration
+	}{
+		measurement: "m_" + t.Name(),
+		start:       year2020,
+		timeout:     10 * time.Second,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fix.timeout)
+	t.Cleanup(cancel)
+
+	config := influx.Config{
+		URL:         dbURL,
+		Org:         org,
+		TokenWrite:  token,
+		TokenRead:   token,
+		Bucket:      bucket,
+		Measurement: fix.measurement,
+	}
+
+	at := func(minutes int) time.Time {
+		return fix.start.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	// the points of the first window are written by different
+	// versions of the app
+	batches := map[string][]*gym.Utilization{
+		"1": {
+			{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10},
+			{Gym: "b", Timestamp: at(1), People: 7, Capacity: 20},
+		},
+		"2": {
+			{Gym: "a", Timestamp: at(2), People: 4, Capacity: 10},
+			{Gym: "a", Timestamp: at(11), People: 5, Capacity: 10},
+		},
+	}
+
+	var store *influx.Store
+
+	for version, data := range batches {
+		config.Fields = map[string]string{influx.VersionFieldKey: version}
+
+		var cancel func()
+		store, cancel = influx.NewStore(config)
+		t.Cleanup(cancel)
+
+		if err := store.Add(ctx, data...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subtests := map[storage.Aggregate][]*gym.Utilization{
+		storage.Mean: {
+			{Gym: "a", Timestamp: at(0), People: 3, Capacity: 10},
+			{Gym: "a", Timestamp: at(10), People: 5, Capacity: 10},
+			{Gym: "b", Timestamp: at(0), People: 7, Capacity: 20},
+		},
+		storage.Min: {
+			{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+			{Gym: "a", Timestamp: at(10), People: 5, Capacity: 10},
+			{Gym: "b", Timestamp: at(0), People: 7, Capacity: 20},
+		},
+	}
+
+	for fn, want := range subtests {
+		fn, want := fn, want
+
+		t.Run(string(fn), func(t *testing.T) {
+			t.Parallel()
+
+			got, err := store.GetAggregated(
+				ctx, at(0), at(20), 10*time.Minute, fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.SliceStable(got, func(i, j int) bool {
+				return got[i].Gym < got[j].Gym
+			})
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInflux_Rollups(t *testing.T) {
+	t.Parallel()
+
+	fix := struct {
+		measurement string
+		start       time.Time
+		timeout     time.Duration
+	}{
+		measurement: "m_" + t.Name(),
+		start:       year2020,
+		timeout:     10 * time.Second,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fix.timeout)
+	t.Cleanup(cancel)
+
+	store, cancelStore := influx.NewStore(influx.Config{
+		URL:         dbURL,
+		Org:         org,
+		TokenWrite:  token,
+		TokenRead:   token,
+		Bucket:      bucket,
+		Measurement: fix.measurement,
+		RollupMeasurements: map[rollup.Tier]string{
+			rollup.Hourly: fix.measurement + "_1h",
+		},
+	})
+	t.Cleanup(cancelStore)
+
+	if _, ok, err := store.LastRollup(ctx, rollup.Hourly); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Error("unexpected last rollup in an empty tier")
+	}
+
+	want := []*rollup.Rollup{
+		{
+			Gym:      "a",
+			Start:    fix.start,
+			Count:    3,
+			People:   rollup.Stats{Mean: 2.5, Min: 1, Max: 4},
+			Capacity: rollup.Stats{Mean: 10, Min: 10, Max: 10},
+		},
+		{
+			Gym:      "b",
+			Start:    fix.start.Add(time.Hour),
+			Count:    1,
+			People:   rollup.Stats{Mean: 7, Min: 7, Max: 7},
+			Capacity: rollup.Stats{Mean: 20, Min: 20, Max: 20},
+		},
+	}
+
+	if err := store.AddRollups(ctx, rollup.Hourly, want...); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetRollups(ctx, rollup.Hourly,
+		fix.start, fix.start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+
+	last, ok, err := store.LastRollup(ctx, rollup.Hourly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || !last.Equal(want[1].Start) {
+		t.Errorf("want last rollup at %v, got %v (%t)",
+			want[1].Start, last, ok)
+	}
+
+	first, ok, err := store.FirstRollup(ctx, rollup.Hourly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || !first.Equal(want[0].Start) {
+		t.Errorf("want first rollup at %v, got %v (%t)",
+			want[0].Start, first, ok)
+	}
+
+	// the daily tier has no measurement
+	if err := store.AddRollups(ctx, rollup.Daily, want...); err == nil {
+		t.Error("unexpected success adding rollups to a tier " +
+			"without measurement")
+	}
+}
@@ -0,0 +1,281 @@
+package influx
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/query"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/alcortesm/sputnik-popularity/app/rollup"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// the fields of the rollup measurements.
+const (
+	countFieldKey        = "count"
+	peopleMeanFieldKey   = "people_mean"
+	peopleMinFieldKey    = "people_min"
+	peopleMaxFieldKey    = "people_max"
+	capacityMeanFieldKey = "capacity_mean"
+	capacityMinFieldKey  = "capacity_min"
+	capacityMaxFieldKey  = "capacity_max"
+)
+
+// rollupMeasurement returns the measurement of the rollups of a tier.
+func (s *Store) rollupMeasurement(tier rollup.Tier) (string, error) {
+	m, ok := s.config.RollupMeasurements[tier]
+	if !ok || m == "" {
+		return "", fmt.Errorf("no measurement for tier %s", tier)
+	}
+
+	return m, nil
+}
+
+// rollupTier returns the longest tier with rollups that can be used to
+// aggregate values in windows of the given duration with the given
+// function, or false if there is none.
+func (s *Store) rollupTier(
+	window time.Duration,
+	fn storage.Aggregate,
+) (rollup.Tier, bool) {
+	if fn == storage.Last {
+		return "", false
+	}
+
+	for i := len(rollup.Tiers) - 1; i >= 0; i-- {
+		tier := rollup.Tiers[i]
+
+		if _, err := s.rollupMeasurement(tier); err != nil {
+			continue
+		}
+
+		if window%tier.Window() == 0 {
+			return tier, true
+		}
+	}
+
+	return "", false
+}
+
+// AddRollups implements rollup.Store. Rollup points only have the gym
+// and schema tags, so the rollups of a window are overwritten when
+// they are recomputed, even by other versions of the app.
+func (s *Store) AddRollups(
+	ctx context.Context,
+	tier rollup.Tier,
+	rollups ...*rollup.Rollup,
+) error {
+	measurement, err := s.rollupMeasurement(tier)
+	if err != nil {
+		return err
+	}
+
+	points := make([]*write.Point, len(rollups))
+
+	for i, r := range rollups {
+		points[i] = influxdb2.NewPoint(
+			measurement,
+			map[string]string{
+				GymTagKey:    r.Gym,
+				SchemaTagKey: Schema,
+			},
+			map[string]interface{}{
+				countFieldKey:        int64(r.Count),
+				peopleMeanFieldKey:   r.People.Mean,
+				peopleMinFieldKey:    r.People.Min,
+				peopleMaxFieldKey:    r.People.Max,
+				capacityMeanFieldKey: r.Capacity.Mean,
+				capacityMinFieldKey:  r.Capacity.Min,
+				capacityMaxFieldKey:  r.Capacity.Max,
+			},
+			r.Start,
+		)
+	}
+
+	return s.write(ctx, points...)
+}
+
+// GetRollups implements rollup.Store.
+func (s *Store) GetRollups(
+	ctx context.Context,
+	tier rollup.Tier,
+	start, end time.Time,
+) ([]*rollup.Rollup, error) {
+	measurement, err := s.rollupMeasurement(tier)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`from(bucket:%q)
+			|> range(start: %s, stop: %s)
+			|> filter( fn: (r) => r._measurement == %q)
+			|> pivot(
+				rowKey:["_time"],
+				columnKey:["_field"],
+				valueColumn: "_value"
+			)`,
+		s.config.Bucket,
+		start.Format(time.RFC3339Nano),
+		end.Format(time.RFC3339Nano),
+		measurement,
+	)
+
+	table, err := s.queryAPI.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+
+	result := []*rollup.Rollup{}
+
+	for table.Next() {
+		r, err := recordToRollup(table.Record())
+		if err != nil {
+			return nil, fmt.Errorf("invalid influx record: %v", err)
+		}
+
+		result = append(result, r)
+	}
+
+	if err := table.Err(); err != nil {
+		return nil, fmt.Errorf("table error: %s", err)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Gym != result[j].Gym {
+			return result[i].Gym < result[j].Gym
+		}
+
+		return result[i].Start.Before(result[j].Start)
+	})
+
+	return result, nil
+}
+
+// FirstRollup implements rollup.Store.
+func (s *Store) FirstRollup(
+	ctx context.Context,
+	tier rollup.Tier,
+) (time.Time, bool, error) {
+	return s.rollupEdge(ctx, tier, "first", false)
+}
+
+// LastRollup implements rollup.Store.
+func (s *Store) LastRollup(
+	ctx context.Context,
+	tier rollup.Tier,
+) (time.Time, bool, error) {
+	return s.rollupEdge(ctx, tier, "last", true)
+}
+
+// rollupEdge returns the start of the first or last rollup of a tier,
+// selected with the given Flux function and sort order, or false if
+// the tier has no rollups.
+func (s *Store) rollupEdge(
+	ctx context.Context,
+	tier rollup.Tier,
+	selector string,
+	desc bool,
+) (time.Time, bool, error) {
+	measurement, err := s.rollupMeasurement(tier)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	// the first or last point of each gym, and then the first or last
+	// of them
+	query := fmt.Sprintf(`from(bucket:%q)
+			|> range(start: 1970-01-01T00:00:00Z)
+			|> filter( fn: (r) =>
+				(r._measurement == %q) and
+				(r._field == %q)
+			)
+			|> %s()
+			|> group()
+			|> sort(columns: ["_time"], desc: %t)
+			|> limit(n: 1)`,
+		s.config.Bucket,
+		measurement,
+		countFieldKey,
+		selector,
+		desc,
+	)
+
+	table, err := s.queryAPI.Query(ctx, query)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("query error: %v", err)
+	}
+
+	var edge time.Time
+	found := false
+
+	for table.Next() {
+		edge, found = table.Record().Time(), true
+	}
+
+	if err := table.Err(); err != nil {
+		return time.Time{}, false, fmt.Errorf("table error: %s", err)
+	}
+
+	return edge, found, nil
+}
+
+func recordToRollup(r *query.FluxRecord) (*rollup.Rollup, error) {
+	result := &rollup.Rollup{
+		Start: r.Time(),
+	}
+
+	at := result.Start.Format(time.RFC3339)
+
+	var err error
+
+	result.Gym, err = recordGym(r)
+	if err != nil {
+		return nil, err
+	}
+
+	count, ok := r.ValueByKey(countFieldKey).(int64)
+	if !ok {
+		return nil, fmt.Errorf("parsing %s field value at %s: "+
+			"want int64, got %T instead", countFieldKey, at,
+			r.ValueByKey(countFieldKey))
+	}
+
+	result.Count = int(count)
+
+	stats := []struct {
+		dst                     *rollup.Stats
+		meanKey, minKey, maxKey string
+	}{
+		{&result.People, peopleMeanFieldKey, peopleMinFieldKey, peopleMaxFieldKey},
+		{&result.Capacity, capacityMeanFieldKey, capacityMinFieldKey, capacityMaxFieldKey},
+	}
+
+	for _, st := range stats {
+		mean, ok := r.ValueByKey(st.meanKey).(float64)
+		if !ok {
+			return nil, fmt.Errorf("parsing %s field value at %s: "+
+				"want float64, got %T instead", st.meanKey, at,
+				r.ValueByKey(st.meanKey))
+		}
+
+		st.dst.Mean = mean
+
+		st.dst.Min, err = toUint64(r.ValueByKey(st.minKey))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s field value at %s: %v",
+				st.minKey, at, err)
+		}
+
+		st.dst.Max, err = toUint64(r.ValueByKey(st.maxKey))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s field value at %s: %v",
+				st.maxKey, at, err)
+		}
+	}
+
+	return result, nil
+}
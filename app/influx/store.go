@@ -2,16 +2,24 @@ package influx
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
 	"github.com/influxdata/influxdb-client-go/v2/api/query"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/influxdata/influxdb-client-go/v2/log"
 
 	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/rollup"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
 )
 
 func init() {
@@ -25,23 +33,95 @@ type Config struct {
 	Org         string
 	Bucket      string
 	Measurement string
+	// AttemptsMeasurement is where the scraping attempts are stored,
+	// see AddAttempts.
+	AttemptsMeasurement string
+	// BatchSize enables batched asynchronous writes: points are
+	// buffered and written when there are BatchSize of them or every
+	// FlushInterval, whatever happens first. Zero disables batching,
+	// so each call to Add or AddAttempts blocks until its points are
+	// written.
+	BatchSize     uint
+	FlushInterval time.Duration
+	// Fields are added to every point, like the app version. They are
+	// string fields and not tags, so they are not part of the series
+	// key: a point overwrites the one of its gym with the same
+	// timestamp even if they were written by different versions.
+	Fields map[string]string
+	// GymFields are added to the points of each gym, by gym name, like
+	// the gym ID or the source used to scrape it.
+	GymFields map[string]map[string]string
+	// Filter makes Get, GetRange, GetAggregated and GetAttempts return
+	// only the points with these field or tag values, like the ones in
+	// Fields and GymFields. InfluxDB doesn't index fields, so filtering
+	// by them pivots and scans every point in the range.
+	Filter map[string]string
+	// LegacyGym is the gym of the untagged points written before the
+	// app supported several gyms, see MigrateLegacy. Empty to return
+	// them with an empty gym.
+	LegacyGym string
+	// RollupMeasurements are the measurements of the rollups of each
+	// tier, see AddRollups. GetAggregated uses the rollups of the
+	// tiers in the map instead of the raw points when possible.
+	RollupMeasurements map[rollup.Tier]string
 }
 
+// errorsBuffer is the capacity of the channel returned by Store.Errors.
+const errorsBuffer = 100
+
+// The tags of every point, see package export for other writers of
+// points.
 const (
+	GymTagKey = "gym"
+	// SchemaTagKey tells the version of the schema of each point,
+	// points without it are from before it was introduced. Version 3
+	// points have the version and the gym ID and source as fields.
+	SchemaTagKey = "schema"
+	Schema       = "3"
+)
+
+const (
+
+	// the fields of the app version and the ID and source of each gym.
+	VersionFieldKey = "version"
+	GymIDFieldKey   = "gym_id"
+	SourceFieldKey  = "source"
+
 	peopleFieldKey   = "people"
 	capacityFieldKey = "capacity"
+	// suspiciousFieldKey is only written for suspicious values, to
+	// keep the points of normal values as they were.
+	suspiciousFieldKey = "suspicious"
+
+	// for the attempts measurement
+	attemptFieldKey = "attempt"
+	latencyFieldKey = "latency" // in seconds
+	statusFieldKey  = "status"
+	// errorFieldKey is only written for failed attempts.
+	errorFieldKey = "error"
 )
 
 type Store struct {
 	config   Config
 	writeAPI api.WriteAPIBlocking
 	queryAPI api.QueryAPI
+	// for batched writes, nil if batching is disabled
+	asyncAPI api.WriteAPI
+	errors   chan error
 }
 
+// NewStore returns a store and a function to close it. When batching
+// is enabled, closing the store flushes the pending points.
 func NewStore(config Config) (store *Store, cancel func()) {
 	opts := influxdb2.DefaultOptions().
 		SetPrecision(time.Second)
 
+	if config.BatchSize > 0 {
+		opts = opts.
+			SetBatchSize(config.BatchSize).
+			SetFlushInterval(uint(config.FlushInterval.Milliseconds()))
+	}
+
 	wc := influxdb2.NewClientWithOptions(
 		config.URL,
 		config.TokenWrite,
@@ -60,6 +140,15 @@ func NewStore(config Config) (store *Store, cancel func()) {
 		queryAPI: rc.QueryAPI(config.Org),
 	}
 
+	if config.BatchSize > 0 {
+		store.asyncAPI = wc.WriteAPI(config.Org, config.Bucket)
+		store.errors = make(chan error, errorsBuffer)
+
+		// the errors must be requested before any write and drained,
+		// or the client would block
+		go store.forwardErrors(store.asyncAPI.Errors())
+	}
+
 	cancel = func() {
 		wc.Close()
 		rc.Close()
@@ -68,39 +157,248 @@ func NewStore(config Config) (store *Store, cancel func()) {
 	return store, cancel
 }
 
-func (s *Store) Add(ctx context.Context, data ...*gym.Utilization) error {
-	points := make([]*write.Point, len(data))
-	{
-		for i, d := range data {
-			noTags := map[string]string(nil)
+// ForwardErrors sends the errors from the client to the errors
+// channel, dropping them if it is full, until the client is closed.
+func (s *Store) forwardErrors(errs <-chan error) {
+	defer close(s.errors)
 
-			fields := map[string]interface{}{
-				peopleFieldKey:   d.People,
-				capacityFieldKey: d.Capacity,
-			}
+	for err := range errs {
+		select {
+		case s.errors <- fmt.Errorf("writing points: %v", err):
+		default:
+		}
+	}
+}
 
-			points[i] = influxdb2.NewPoint(
-				s.config.Measurement,
-				noTags,
-				fields,
-				d.Timestamp,
-			)
+// Errors returns the errors of batched writes, or nil if batching is
+// disabled. Errors are dropped if they are not received fast enough.
+// The channel is closed when the store is closed.
+func (s *Store) Errors() <-chan error {
+	return s.errors
+}
+
+// Flush writes the buffered points right away, when batching is
+// enabled.
+func (s *Store) Flush() {
+	if s.asyncAPI != nil {
+		s.asyncAPI.Flush()
+	}
+}
+
+// Write writes the points, or buffers them if batching is enabled.
+func (s *Store) write(ctx context.Context, points ...*write.Point) error {
+	if s.asyncAPI != nil {
+		for _, p := range points {
+			s.asyncAPI.WritePoint(p)
 		}
+
+		return nil
 	}
 
 	if err := s.writeAPI.WritePoint(ctx, points...); err != nil {
-		return fmt.Errorf("writing points: %v", err)
+		if permanent(err) {
+			err = &storage.PermanentError{Err: err}
+		}
+
+		return fmt.Errorf("writing points: %w", err)
 	}
 
 	return nil
 }
 
+// Permanent returns if a write error is a rejection of the points
+// themselves, like a field type conflict, and not an error that can be
+// fixed without changing them, like a missing authorization or bucket,
+// a timeout or too many requests.
+func permanent(err error) bool {
+	var httpErr *http.Error
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+
+	switch httpErr.StatusCode {
+	case 401, 403, 404, 408, 429:
+		return false
+	default:
+		return httpErr.StatusCode >= 400 && httpErr.StatusCode < 500
+	}
+}
+
+// Tags returns the tags for the points of the given gym, only the
+// ones that identify its series.
+func (s *Store) tags(gymName string) map[string]string {
+	return map[string]string{
+		GymTagKey:    gymName,
+		SchemaTagKey: Schema,
+	}
+}
+
+// AddFields adds the configured fields for the points of the given gym
+// to the fields of a point.
+func (s *Store) addFields(fields map[string]interface{}, gymName string) {
+	for k, v := range s.config.Fields {
+		fields[k] = v
+	}
+
+	for k, v := range s.config.GymFields[gymName] {
+		fields[k] = v
+	}
+}
+
+// Add stores the utilization data. When batching is enabled, it
+// returns right away and write errors are reported through Errors.
+func (s *Store) Add(ctx context.Context, data ...*gym.Utilization) error {
+	return s.write(ctx, s.points(data)...)
+}
+
+// Points returns the points for the utilization data.
+func (s *Store) points(data []*gym.Utilization) []*write.Point {
+	points := make([]*write.Point, len(data))
+
+	for i, d := range data {
+		fields := map[string]interface{}{
+			peopleFieldKey:   d.People,
+			capacityFieldKey: d.Capacity,
+		}
+
+		if d.Suspicious {
+			fields[suspiciousFieldKey] = true
+		}
+
+		s.addFields(fields, d.Gym)
+
+		points[i] = influxdb2.NewPoint(
+			s.config.Measurement,
+			s.tags(d.Gym),
+			fields,
+			d.Timestamp,
+		)
+	}
+
+	return points
+}
+
+// Get returns the utilization data since the given time.
 func (s *Store) Get(
 	ctx context.Context,
 	since time.Time,
 ) ([]*gym.Utilization, error) {
+	bounds := fmt.Sprintf("start: %s", since.Format(time.RFC3339))
+
+	return s.get(ctx, bounds)
+}
+
+// GetRange returns the utilization data from start (inclusive) to end
+// (exclusive).
+func (s *Store) GetRange(
+	ctx context.Context,
+	start, end time.Time,
+) ([]*gym.Utilization, error) {
+	bounds := fmt.Sprintf("start: %s, stop: %s",
+		start.Format(time.RFC3339Nano),
+		end.Format(time.RFC3339Nano),
+	)
+
+	return s.get(ctx, bounds)
+}
+
+// get returns the utilization data in the range with the given
+// bounds, which are the arguments of the Flux range function, and the
+// values of the filter in the config.
+//
+// Untagged points are returned as points of the legacy gym, unless
+// there is a tagged point of that gym with the same timestamp, like
+// the ones written by MigrateLegacy.
+func (s *Store) get(
+	ctx context.Context,
+	bounds string,
+) ([]*gym.Utilization, error) {
+	data, err := s.query(ctx, bounds, filterExpr(s.config.Filter))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.resolveLegacy(data), nil
+}
+
+// resolveLegacy returns the data with the untagged points assigned to
+// the legacy gym, removing the ones that have a tagged point of that
+// gym with the same timestamp.
+func (s *Store) resolveLegacy(data []*gym.Utilization) []*gym.Utilization {
+	type key struct {
+		gym string
+		ts  int64
+	}
+
+	tagged := map[key]bool{}
+
+	for _, d := range data {
+		if d.Gym != "" {
+			tagged[key{gym: d.Gym, ts: d.Timestamp.UnixNano()}] = true
+		}
+	}
+
+	result := make([]*gym.Utilization, 0, len(data))
+
+	for _, d := range data {
+		if d.Gym == "" {
+			d.Gym = s.config.LegacyGym
+
+			if tagged[key{gym: d.Gym, ts: d.Timestamp.UnixNano()}] {
+				continue
+			}
+		}
+
+		result = append(result, d)
+	}
+
+	return result
+}
+
+// GetAggregated implements storage.Aggregator, using the rollups of
+// the longest tier that fits the window, if any, or the Flux
+// aggregateWindow function. The window must be a whole number of
+// seconds.
+//
+// Rollups and aggregated points have no fields to filter them by, so
+// when the config has a filter, the raw points are downsampled
+// instead.
+//
+// Aggregated values are never suspicious, and the mean people and
+// capacity are rounded to the nearest integer.
+func (s *Store) GetAggregated(
+	ctx context.Context,
+	start, end time.Time,
+	window time.Duration,
+	fn storage.Aggregate,
+) ([]*gym.Utilization, error) {
+	if window < time.Second || window%time.Second != 0 {
+		return nil, fmt.Errorf("invalid window %v: "+
+			"want a whole number of seconds", window)
+	}
+
+	if err := fn.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(s.config.Filter) > 0 {
+		data, err := s.GetRange(ctx, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		return storage.Downsample(data, window, fn)
+	}
+
+	if tier, ok := s.rollupTier(window, fn); ok {
+		return rollup.Aggregated(ctx, s, s, tier, start, end, window, fn)
+	}
+
+	// the untagged legacy points and the ones of each schema version
+	// are in different series, so they are grouped by gym before
+	// aggregating them.
 	query := fmt.Sprintf(`from(bucket:%q)
-			|> range(start: %s)
+			|> range(start: %s, stop: %s)
 			|> filter( fn: (r) =>
 				(r._measurement == %q) and
 				(
@@ -108,16 +406,93 @@ func (s *Store) Get(
 					(r._field == %q)
 				)
 			)
+			|> toFloat()
+			|> group(columns: [%q, "_field"])
+			|> aggregateWindow(
+				every: %ds,
+				fn: %s,
+				timeSrc: "_start",
+				createEmpty: false
+			)
 			|> pivot(
 				rowKey:["_time"],
 				columnKey:["_field"],
 				valueColumn: "_value"
 			)`,
 		s.config.Bucket,
-		since.Format(time.RFC3339),
+		start.Format(time.RFC3339Nano),
+		end.Format(time.RFC3339Nano),
 		s.config.Measurement,
 		peopleFieldKey,
 		capacityFieldKey,
+		GymTagKey,
+		window/time.Second,
+		fn,
+	)
+
+	table, err := s.queryAPI.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+
+	result := []*gym.Utilization{}
+
+	for table.Next() {
+		u, err := recordToAggregate(table.Record())
+		if err != nil {
+			return nil, fmt.Errorf("invalid influx record: %v", err)
+		}
+
+		result = append(result, u)
+	}
+
+	if err := table.Err(); err != nil {
+		return nil, fmt.Errorf("table error: %s", err)
+	}
+
+	return s.resolveLegacy(result), nil
+}
+
+// FilterExpr returns a Flux expression to filter records by the given
+// column values, sorted by column, like ` and (r["a"] == "b")`.
+func filterExpr(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, " and (r[%q] == %q)", k, tags[k])
+	}
+
+	return b.String()
+}
+
+// query returns the utilization data in the range with the given
+// bounds that also matches the given filter, a Flux expression like
+// the ones returned by filterExpr. The filter is applied after
+// pivoting the fields, so it can use their values as well as the tags.
+func (s *Store) query(
+	ctx context.Context,
+	bounds string,
+	filter string,
+) ([]*gym.Utilization, error) {
+	query := fmt.Sprintf(`from(bucket:%q)
+			|> range(%s)
+			|> filter( fn: (r) => r._measurement == %q)
+			|> pivot(
+				rowKey:["_time"],
+				columnKey:["_field"],
+				valueColumn: "_value"
+			)
+			|> filter( fn: (r) => true%s)`,
+		s.config.Bucket,
+		bounds,
+		s.config.Measurement,
+		filter,
 	)
 
 	table, err := s.queryAPI.Query(ctx, query)
@@ -143,14 +518,65 @@ func (s *Store) Get(
 	return result, nil
 }
 
+// MigrateLegacy rewrites the untagged points in the given range, from
+// before the app supported several gyms, as tagged points of the
+// legacy gym, and returns how many points were rewritten. It is safe
+// to run it several times.
+//
+// The untagged points are kept, but Get and GetRange ignore them once
+// they have been rewritten.
+func (s *Store) MigrateLegacy(
+	ctx context.Context,
+	start, end time.Time,
+) (int, error) {
+	if s.config.LegacyGym == "" {
+		return 0, fmt.Errorf("missing legacy gym")
+	}
+
+	bounds := fmt.Sprintf("start: %s, stop: %s",
+		start.Format(time.RFC3339Nano),
+		end.Format(time.RFC3339Nano),
+	)
+
+	filter := fmt.Sprintf(" and not exists r[%q]", GymTagKey)
+
+	data, err := s.query(ctx, bounds, filter)
+	if err != nil {
+		return 0, fmt.Errorf("reading legacy points: %v", err)
+	}
+
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	for _, d := range data {
+		d.Gym = s.config.LegacyGym
+	}
+
+	points := s.points(data)
+
+	// bypass batching, to report errors
+	if err := s.writeAPI.WritePoint(ctx, points...); err != nil {
+		return 0, fmt.Errorf("writing migrated points: %v", err)
+	}
+
+	return len(points), nil
+}
+
 func recordToUtilization(r *query.FluxRecord) (*gym.Utilization, error) {
 	result := &gym.Utilization{
 		Timestamp: r.Time(),
 	}
 
-	raw := r.ValueByKey(peopleFieldKey)
 	var err error
 
+	result.Gym, err = recordGym(r)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := r.ValueByKey(peopleFieldKey)
+
 	result.People, err = toUint64(raw)
 	if err != nil {
 		return nil, fmt.Errorf("parsing %s field value at %s: %v",
@@ -167,6 +593,18 @@ func recordToUtilization(r *query.FluxRecord) (*gym.Utilization, error) {
 			result.Timestamp.Format(time.RFC3339), err)
 	}
 
+	// the field is missing for values that are not suspicious.
+	if raw := r.ValueByKey(suspiciousFieldKey); raw != nil {
+		suspicious, ok := raw.(bool)
+		if !ok {
+			return nil, fmt.Errorf("parsing %s field value at %s: "+
+				"want bool, got %T instead", suspiciousFieldKey,
+				result.Timestamp.Format(time.RFC3339), raw)
+		}
+
+		result.Suspicious = suspicious
+	}
+
 	if result.Capacity == 0 {
 		return nil, fmt.Errorf("capacity at %s is 0",
 			result.Timestamp.Format(time.RFC3339),
@@ -176,6 +614,78 @@ func recordToUtilization(r *query.FluxRecord) (*gym.Utilization, error) {
 	return result, nil
 }
 
+// recordToAggregate is like recordToUtilization for the records of
+// aggregated points, which have float values.
+func recordToAggregate(r *query.FluxRecord) (*gym.Utilization, error) {
+	result := &gym.Utilization{
+		Timestamp: r.Time(),
+	}
+
+	var err error
+
+	result.Gym, err = recordGym(r)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := r.ValueByKey(peopleFieldKey)
+
+	result.People, err = roundFloat(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s field value at %s: %v",
+			peopleFieldKey,
+			result.Timestamp.Format(time.RFC3339), err)
+	}
+
+	raw = r.ValueByKey(capacityFieldKey)
+
+	result.Capacity, err = roundFloat(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s field value at %s: %v",
+			capacityFieldKey,
+			result.Timestamp.Format(time.RFC3339), err)
+	}
+
+	if result.Capacity == 0 {
+		return nil, fmt.Errorf("capacity at %s is 0",
+			result.Timestamp.Format(time.RFC3339),
+		)
+	}
+
+	return result, nil
+}
+
+// recordGym returns the value of the gym tag of a record. Untagged
+// points, from before we supported several gyms, have an empty gym.
+func recordGym(r *query.FluxRecord) (string, error) {
+	raw := r.ValueByKey(GymTagKey)
+	if raw == nil {
+		return "", nil
+	}
+
+	name, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("parsing %s tag value at %s: "+
+			"want string, got %T instead", GymTagKey,
+			r.Time().Format(time.RFC3339), raw)
+	}
+
+	return name, nil
+}
+
+func roundFloat(v interface{}) (uint64, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("want float64, got %T instead", v)
+	}
+
+	if f < 0 {
+		return 0, fmt.Errorf("negative value %v", f)
+	}
+
+	return uint64(math.Round(f)), nil
+}
+
 func toUint64(v interface{}) (uint64, error) {
 	result, ok := v.(uint64)
 	if !ok {
@@ -184,3 +694,137 @@ func toUint64(v interface{}) (uint64, error) {
 
 	return result, nil
 }
+
+// AddAttempts stores scraping attempts in the attempts measurement,
+// batching them like Add.
+func (s *Store) AddAttempts(
+	ctx context.Context,
+	attempts ...*scrape.Attempt,
+) error {
+	points := make([]*write.Point, len(attempts))
+	{
+		for i, a := range attempts {
+			fields := map[string]interface{}{
+				attemptFieldKey: int64(a.Number),
+				latencyFieldKey: a.Latency.Seconds(),
+				statusFieldKey:  int64(a.Status),
+			}
+
+			if a.Error != "" {
+				fields[errorFieldKey] = string(a.Error)
+			}
+
+			s.addFields(fields, a.Gym)
+
+			points[i] = influxdb2.NewPoint(
+				s.config.AttemptsMeasurement,
+				s.tags(a.Gym),
+				fields,
+				a.Timestamp,
+			)
+		}
+	}
+
+	return s.write(ctx, points...)
+}
+
+// GetAttempts returns the scraping attempts since the given time with
+// the values of the filter in the config.
+func (s *Store) GetAttempts(
+	ctx context.Context,
+	since time.Time,
+) ([]*scrape.Attempt, error) {
+	query := fmt.Sprintf(`from(bucket:%q)
+			|> range(start: %s)
+			|> filter( fn: (r) => r._measurement == %q)
+			|> pivot(
+				rowKey:["_time"],
+				columnKey:["_field"],
+				valueColumn: "_value"
+			)
+			|> filter( fn: (r) => true%s)`,
+		s.config.Bucket,
+		since.Format(time.RFC3339),
+		s.config.AttemptsMeasurement,
+		filterExpr(s.config.Filter),
+	)
+
+	table, err := s.queryAPI.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+
+	result := []*scrape.Attempt{}
+
+	for table.Next() {
+		a, err := recordToAttempt(table.Record())
+		if err != nil {
+			return nil, fmt.Errorf("invalid influx record: %v", err)
+		}
+
+		result = append(result, a)
+	}
+
+	if err := table.Err(); err != nil {
+		return nil, fmt.Errorf("table error: %s", err)
+	}
+
+	return result, nil
+}
+
+func recordToAttempt(r *query.FluxRecord) (*scrape.Attempt, error) {
+	result := &scrape.Attempt{
+		Timestamp: r.Time(),
+	}
+
+	at := result.Timestamp.Format(time.RFC3339)
+
+	name, ok := r.ValueByKey(GymTagKey).(string)
+	if !ok {
+		return nil, fmt.Errorf("parsing %s tag value at %s: "+
+			"want string, got %T instead", GymTagKey, at,
+			r.ValueByKey(GymTagKey))
+	}
+
+	result.Gym = name
+
+	number, ok := r.ValueByKey(attemptFieldKey).(int64)
+	if !ok {
+		return nil, fmt.Errorf("parsing %s field value at %s: "+
+			"want int64, got %T instead", attemptFieldKey, at,
+			r.ValueByKey(attemptFieldKey))
+	}
+
+	result.Number = int(number)
+
+	latency, ok := r.ValueByKey(latencyFieldKey).(float64)
+	if !ok {
+		return nil, fmt.Errorf("parsing %s field value at %s: "+
+			"want float64, got %T instead", latencyFieldKey, at,
+			r.ValueByKey(latencyFieldKey))
+	}
+
+	result.Latency = time.Duration(latency * float64(time.Second))
+
+	status, ok := r.ValueByKey(statusFieldKey).(int64)
+	if !ok {
+		return nil, fmt.Errorf("parsing %s field value at %s: "+
+			"want int64, got %T instead", statusFieldKey, at,
+			r.ValueByKey(statusFieldKey))
+	}
+
+	result.Status = int(status)
+
+	// the field is missing for successful attempts.
+	if raw := r.ValueByKey(errorFieldKey); raw != nil {
+		class, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("parsing %s field value at %s: "+
+				"want string, got %T instead", errorFieldKey, at, raw)
+		}
+
+		result.Error = scrape.ErrorClass(class)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package recent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/pkg/atomicfile"
+)
+
+// snapshotVersion is the version of the format of snapshot files,
+// snapshots of other versions are not read.
+const snapshotVersion = 1
+
+// snapshotFile is the content of snapshot files.
+type snapshotFile struct {
+	Version int
+	// Checksum is the CRC-32 (IEEE) of Data, to detect corrupt
+	// snapshots.
+	Checksum uint32
+	Data     json.RawMessage // the values, as a JSON array
+}
+
+// WriteSnapshot writes the values in the store to a file, so they can
+// be restored with ReadSnapshot after a restart.
+//
+// The file is replaced atomically: a crash while writing it leaves the
+// previous snapshot, if any, untouched.
+func (r *Store) WriteSnapshot(path string) error {
+	// the values are never modified, only replaced, so they can be
+	// encoded without holding the mutex
+	r.mux.Lock()
+	values := make([]*gym.Utilization, len(r.data))
+	copy(values, r.data)
+	r.mux.Unlock()
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("encoding values: %v", err)
+	}
+
+	b, err := json.Marshal(snapshotFile{
+		Version:  snapshotVersion,
+		Checksum: crc32.ChecksumIEEE(data),
+		Data:     data,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %v", err)
+	}
+
+	if err := atomicfile.WriteFile(path, b, 0o644); err != nil {
+		return fmt.Errorf("writing snapshot: %v", err)
+	}
+
+	return nil
+}
+
+// ReadSnapshot adds the values in a snapshot file written by
+// WriteSnapshot to the store, like Add. If the file is corrupt or has
+// an unsupported version, it fails without adding any value. The error
+// wraps the os.ErrNotExist error if the file doesn't exist.
+func (r *Store) ReadSnapshot(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	var f snapshotFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("decoding snapshot: %v", err)
+	}
+
+	if f.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", f.Version)
+	}
+
+	if sum := crc32.ChecksumIEEE(f.Data); sum != f.Checksum {
+		return fmt.Errorf("corrupt snapshot: checksum is %08x, want %08x",
+			sum, f.Checksum)
+	}
+
+	var data []*gym.Utilization
+	if err := json.Unmarshal(f.Data, &data); err != nil {
+		return fmt.Errorf("decoding values: %v", err)
+	}
+
+	for i, d := range data {
+		if d == nil {
+			return fmt.Errorf("corrupt snapshot: value #%d is null", i)
+		}
+	}
+
+	return r.Add(context.Background(), data...)
+}
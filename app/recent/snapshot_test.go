@@ -0,0 +1,217 @@
+package recent_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/recent"
+)
+
+func TestStore_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]func(t *testing.T){
+		"restores the values":         restoresValues,
+		"replaces previous snapshots": replacesSnapshots,
+		"missing snapshot":            missingSnapshot,
+		"ignores corrupt snapshots":   ignoresCorruptSnapshots,
+	}
+
+	for name, fn := range subtests {
+		fn := fn
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			fn(t)
+		})
+	}
+}
+
+// snapshotOf writes a snapshot of a new store with the given values and
+// returns its path.
+func snapshotOf(t *testing.T, data ...*gym.Utilization) string {
+	t.Helper()
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Add(context.Background(), data...); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	if err := store.WriteSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func restoresValues(t *testing.T) {
+	data := []*gym.Utilization{
+		fixValue(t, 1),
+		fixValue(t, 2),
+		fixValue(t, 3),
+	}
+	data[1].Suspicious = true
+
+	path := snapshotOf(t, data...)
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// a newer value, added before restoring
+	if err := store.Add(ctx, fixValue(t, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.ReadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := append(data, fixValue(t, 4))
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func replacesSnapshots(t *testing.T) {
+	path := snapshotOf(t, fixValue(t, 1))
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	if err := store.Add(ctx, fixValue(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.WriteSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Errorf("want 1 file, got %d", len(files))
+	}
+
+	restored, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restored.ReadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := restored.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{fixValue(t, 2)}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func missingSnapshot(t *testing.T) {
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "missing.json")
+
+	err = store.ReadSnapshot(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want a not exist error, got %v", err)
+	}
+}
+
+func ignoresCorruptSnapshots(t *testing.T) {
+	path := snapshotOf(t, fixValue(t, 1), fixValue(t, 2))
+
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corruptions := map[string]func([]byte) []byte{
+		"truncated": func(b []byte) []byte {
+			return b[:len(b)/2]
+		},
+		"empty": func([]byte) []byte {
+			return nil
+		},
+		"modified value": func(b []byte) []byte {
+			return bytes.Replace(b, []byte(`"People":2`),
+				[]byte(`"People":3`), 1)
+		},
+		"unknown version": func(b []byte) []byte {
+			return bytes.Replace(b, []byte(`"Version":1`),
+				[]byte(`"Version":2`), 1)
+		},
+	}
+
+	for name, corrupt := range corruptions {
+		corrupted := corrupt(original)
+		if bytes.Equal(corrupted, original) {
+			t.Fatalf("%s: the snapshot was not modified", name)
+		}
+
+		path := filepath.Join(t.TempDir(), "snapshot.json")
+
+		if err := ioutil.WriteFile(path, corrupted, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err := recent.NewStore(time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.ReadSnapshot(path); err == nil {
+			t.Errorf("%s: unexpected success", name)
+		}
+
+		got, err := store.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 0 {
+			t.Errorf("%s: want no values, got %v", name, got)
+		}
+	}
+}
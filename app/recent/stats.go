@@ -0,0 +1,162 @@
+package recent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Stats summarizes the values in a time window.
+type Stats struct {
+	Count   int // the number of values
+	People  Summary
+	Percent Summary // only of the values with a capacity
+}
+
+// Summary summarizes a quantity.
+type Summary struct {
+	Count int
+	Min   float64
+	Max   float64
+	Mean  float64
+	// the values, in no particular order, for the percentiles
+	values []float64
+}
+
+// Latest returns the newest value in the store, or false if the store
+// is empty.
+func (r *Store) Latest(_ context.Context) (*gym.Utilization, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if len(r.data) == 0 {
+		return nil, false
+	}
+
+	return r.data[len(r.data)-1], true
+}
+
+// Stats returns the stats of the values from start (inclusive) to end
+// (exclusive). The mutex is only held to find and copy the values, in
+// logarithmic time, the stats are computed afterwards in linear time.
+func (r *Store) Stats(ctx context.Context, start, end time.Time) (
+	*Stats, error) {
+	data, err := r.GetRange(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Stats{
+		Count: len(data),
+		People: Summary{
+			values: make([]float64, 0, len(data)),
+		},
+		Percent: Summary{
+			values: make([]float64, 0, len(data)),
+		},
+	}
+
+	for _, d := range data {
+		result.People.add(float64(d.People))
+
+		if p, ok := d.Percent(); ok {
+			result.Percent.add(p)
+		}
+	}
+
+	return result, nil
+}
+
+// add adds a value to the summary.
+func (s *Summary) add(v float64) {
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+
+	s.Count++
+	s.Mean += (v - s.Mean) / float64(s.Count)
+	s.values = append(s.values, v)
+}
+
+// Percentile returns the pth percentile of the values, from 0 to 100,
+// interpolating linearly between the closest values, so the 0th is the
+// min, the 50th the median and the 100th the max. It runs in linear
+// time and fails if there are no values.
+func (s *Summary) Percentile(p float64) (float64, error) {
+	if p < 0 || p > 100 || math.IsNaN(p) {
+		return 0, fmt.Errorf("invalid percentile %v: must be from 0 to 100", p)
+	}
+
+	if len(s.values) == 0 {
+		return 0, errors.New("no values")
+	}
+
+	values := make([]float64, len(s.values))
+	copy(values, s.values)
+
+	rank := p / 100 * float64(len(values)-1)
+	i := int(rank)
+
+	lower := nth(values, i)
+	if frac := rank - float64(i); frac > 0 {
+		// nth leaves the values after the ith not lower than it
+		upper := values[i+1]
+		for _, v := range values[i+2:] {
+			if v < upper {
+				upper = v
+			}
+		}
+
+		return lower + frac*(upper-lower), nil
+	}
+
+	return lower, nil
+}
+
+// nth returns the nth lowest of the values (from 0) in linear time on
+// average, reordering them so the ones before it are not higher and
+// the ones after it are not lower.
+func nth(values []float64, n int) float64 {
+	lo, hi := 0, len(values)-1
+
+	for lo < hi {
+		// partition around the middle value
+		pivot := values[lo+(hi-lo)/2]
+		i, j := lo, hi
+
+		for i <= j {
+			for values[i] < pivot {
+				i++
+			}
+
+			for values[j] > pivot {
+				j--
+			}
+
+			if i <= j {
+				values[i], values[j] = values[j], values[i]
+				i++
+				j--
+			}
+		}
+
+		switch {
+		case n <= j:
+			hi = j
+		case n >= i:
+			lo = i
+		default:
+			return values[n]
+		}
+	}
+
+	return values[n]
+}
@@ -0,0 +1,196 @@
+package recent_test
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/recent"
+)
+
+func TestStore_Latest(t *testing.T) {
+	t.Parallel()
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	if _, ok := store.Latest(ctx); ok {
+		t.Error("unexpected value in empty store")
+	}
+
+	if err := store.Add(ctx, fixValue(t, 3), fixValue(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := store.Latest(ctx)
+	if !ok {
+		t.Fatal("missing value")
+	}
+
+	if diff := cmp.Diff(fixValue(t, 3), got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestStore_Stats(t *testing.T) {
+	t.Parallel()
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// people from 1 to 5 and a value without capacity
+	data := []*gym.Utilization{
+		fixValue(t, 1),
+		fixValue(t, 2),
+		fixValue(t, 3),
+		fixValue(t, 4),
+		fixValue(t, 5),
+		fixValue(t, 6), // outside the window
+	}
+	data[0].Capacity = 0
+	data[1].Capacity = 4 // 50%
+	data[2].Capacity = 3 // 100%
+	data[3].Capacity = 8 // 50%
+	data[4].Capacity = 5 // 100%
+
+	ctx := context.Background()
+
+	if err := store.Add(ctx, data...); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Stats(ctx, data[0].Timestamp, data[5].Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &recent.Stats{
+		Count:   5,
+		People:  recent.Summary{Count: 5, Min: 1, Max: 5, Mean: 3},
+		Percent: recent.Summary{Count: 4, Min: 50, Max: 100, Mean: 75},
+	}
+
+	if diff := cmp.Diff(want, got,
+		cmpopts.IgnoreUnexported(recent.Summary{})); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+
+	percentiles := map[float64]float64{
+		0:   1,
+		25:  2,
+		50:  3,
+		60:  3.4,
+		100: 5,
+	}
+
+	for p, want := range percentiles {
+		got, err := got.People.Percentile(p)
+		if err != nil {
+			t.Fatalf("percentile %v: %v", p, err)
+		}
+
+		if d := got - want; d > 1e-9 || d < -1e-9 {
+			t.Errorf("percentile %v: want %v, got %v", p, want, got)
+		}
+	}
+
+	median, err := got.Percent.Percentile(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if median != 75 {
+		t.Errorf("percent median: want 75, got %v", median)
+	}
+}
+
+func TestStore_Stats_Empty(t *testing.T) {
+	t.Parallel()
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	got, err := store.Stats(context.Background(), now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Count != 0 {
+		t.Errorf("want no values, got %d", got.Count)
+	}
+
+	if _, err := got.People.Percentile(50); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestSummary_Percentile(t *testing.T) {
+	t.Parallel()
+
+	rnd := rand.New(rand.NewSource(42))
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// random people, with many repeated values
+	data := make([]*gym.Utilization, 1000)
+	people := make([]float64, len(data))
+
+	for i := range data {
+		data[i] = fixValue(t, i+1)
+		data[i].People = uint64(rnd.Intn(50))
+		people[i] = float64(data[i].People)
+	}
+
+	ctx := context.Background()
+
+	if err := store.Add(ctx, data...); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := store.Stats(ctx, data[0].Timestamp,
+		data[len(data)-1].Timestamp.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Float64s(people)
+
+	// the percentiles that fall exactly on a value
+	for i := 0; i < len(people); i += 37 {
+		p := 100 * float64(i) / float64(len(people)-1)
+
+		got, err := stats.People.Percentile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d := got - people[i]; d > 1e-9 || d < -1e-9 {
+			t.Errorf("percentile %v: want %v, got %v", p, people[i], got)
+		}
+	}
+
+	for _, p := range []float64{-1, 101} {
+		if _, err := stats.People.Percentile(p); err == nil {
+			t.Errorf("percentile %v: unexpected success", p)
+		}
+	}
+}
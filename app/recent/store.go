@@ -23,11 +23,16 @@ import (
 // respect of the newest value.
 //
 // Note: The store doesn't care about when the values are added just
-// about their timestamps.
+// about their timestamps, unless it is created with NewClockStore.
+//
+// The changes to the store can be received as they happen with
+// Subscribe.
 type Store struct {
-	retention time.Duration
-	mux       sync.Mutex
-	data      []*gym.Utilization
+	retention   time.Duration
+	clock       Clock // nil to only forget values by the newest one
+	mux         sync.Mutex
+	data        []*gym.Utilization
+	subscribers map[*subscriber]struct{}
 }
 
 // NewStore returns a new Store with the given retention period.
@@ -39,74 +44,241 @@ func NewStore(retention time.Duration) (*Store, error) {
 	return &Store{retention: retention}, nil
 }
 
+// Clock returns the current time.
+type Clock func() time.Time
+
+// NewClockStore returns a new Store with the given retention period
+// that also forgets the values older than the current time minus the
+// retention period, so it doesn't keep old values as if they were
+// recent when no new values are added for a while, like when the
+// scraping stops. Values are forgotten as new values are added, and
+// also by Sweep, which is called regularly by RunSweeper.
+func NewClockStore(retention time.Duration, clock Clock) (*Store, error) {
+	store, err := NewStore(retention)
+	if err != nil {
+		return nil, err
+	}
+
+	store.clock = clock
+
+	return store, nil
+}
+
+// Sweep forgets the values older than the current time minus the
+// retention period, if the store was created with NewClockStore.
+func (r *Store) Sweep() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.trim()
+}
+
+// RunSweeper calls Sweep every interval until the context is
+// cancelled, and returns its error.
+func (r *Store) RunSweeper(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be >0, was %v", interval)
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			r.Sweep()
+		}
+	}
+}
+
 // Add adds values to the store, overwriting previous values with the
 // same timestamps as the ones being added. After adding these values,
 // all values in the store older than its newest value minus the
 // retention period will be forgotten.
+//
+// Adding values newer than the ones in the store, like scraped ones,
+// and overwriting values, like when refreshing the store from a
+// database, don't need to move the stored values. Other values are
+// merged with the stored ones in linear time.
 func (r *Store) Add(_ context.Context, data ...*gym.Utilization) error {
 	if len(data) == 0 {
 		return nil
 	}
 
+	batch := sortedUnique(data)
+
 	r.mux.Lock()
 	defer r.mux.Unlock()
 
-	r.data = append(r.data, data...)
+	// the values that are new or different, for the subscribers
+	changed := batch
 
-	// stability here and the unique method below helps to overwrite
-	// elements with repeated timestamps with the values that has been
-	// added later.
-	sort.SliceStable(r.data, func(i, j int) bool {
-		return r.data[i].Timestamp.Before(r.data[j].Timestamp)
-	})
+	switch {
+	case len(r.data) == 0 || batch[0].Timestamp.After(r.newest()):
+		r.data = append(r.data, batch...)
+	default:
+		var ok bool
+		if changed, ok = r.overwrite(batch); !ok {
+			r.data, changed = merge(r.data, batch)
+		}
+	}
 
 	r.trim()
-	r.unique()
+	r.publish(changed)
 
 	return nil
 }
 
-// Trim removes elements from r.data with a timestamp older than the
-// timestamp of the newest element minus retention.  It assumes the
-// elements are sorted chronologically and the mutex is locked.
-func (r *Store) trim() {
-	newest := r.data[len(r.data)-1]
+// SortedUnique returns the values sorted chronologically, keeping only
+// the last one of the values with the same timestamp. The values are
+// not modified.
+func sortedUnique(data []*gym.Utilization) []*gym.Utilization {
+	result := make([]*gym.Utilization, len(data))
+	copy(result, data)
 
-	// elements before threshold will be forgotten
-	threshold := newest.Timestamp.Add(-r.retention)
+	if !sort.SliceIsSorted(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	}) {
+		// stability keeps the values with the same timestamp in the
+		// order they were added, so the last one wins below
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Timestamp.Before(result[j].Timestamp)
+		})
+	}
+
+	unique := result[:1]
 
-	// find the index of the first element to keep, this is, the older
-	// element after the threshold
-	first := 0
-	for ; r.data[first].Timestamp.Before(threshold); first++ {
+	for _, d := range result[1:] {
+		l := len(unique) - 1 // index of the last unique element
+		if d.Timestamp.Equal(unique[l].Timestamp) {
+			unique[l] = d
+		} else {
+			unique = append(unique, d)
+		}
 	}
 
-	r.data = r.data[first:]
+	return unique
+}
+
+// Newest returns the timestamp of the newest value. It assumes the
+// store is not empty and the mutex is locked.
+func (r *Store) newest() time.Time {
+	return r.data[len(r.data)-1].Timestamp
+}
+
+// search returns the index of the first value not older than t, or
+// the number of values if there is none. It assumes the mutex is
+// locked.
+func (r *Store) search(t time.Time) int {
+	return sort.Search(len(r.data), func(i int) bool {
+		return !r.data[i].Timestamp.Before(t)
+	})
+}
+
+// Overwrite replaces the stored values with the values in the sorted
+// batch that have the same timestamps, and returns the values that
+// were different and true, if all of them have a stored value with the
+// same timestamp. Otherwise it returns false and the store is not
+// modified. It assumes the mutex is locked.
+func (r *Store) overwrite(batch []*gym.Utilization) (
+	[]*gym.Utilization, bool) {
+	indexes := make([]int, len(batch))
+	from := 0
+
+	for i, b := range batch {
+		j := from + sort.Search(len(r.data)-from, func(k int) bool {
+			return !r.data[from+k].Timestamp.Before(b.Timestamp)
+		})
+
+		if j == len(r.data) || !r.data[j].Timestamp.Equal(b.Timestamp) {
+			return nil, false
+		}
+
+		indexes[i] = j
+		from = j + 1
+	}
+
+	var changed []*gym.Utilization
+
+	for i, j := range indexes {
+		if !r.data[j].Equal(batch[i]) {
+			changed = append(changed, batch[i])
+		}
+
+		r.data[j] = batch[i]
+	}
+
+	return changed, true
 }
 
-// Unique removes duplicated values from the store, keeping the ones
-// that show up later in the data. It assumes the data is sorted
-// chronologically and the mutex is locked.
-func (r *Store) unique() {
-	if len(r.data) < 2 {
+// merge returns the values in two sorted slices of unique values,
+// sorted, keeping the values of the batch when both have values with
+// the same timestamp, and the values of the batch that were new or
+// different.
+func merge(data, batch []*gym.Utilization) (
+	result, changed []*gym.Utilization) {
+	result = make([]*gym.Utilization, 0, len(data)+len(batch))
+
+	i, j := 0, 0
+	for i < len(data) && j < len(batch) {
+		switch a, b := data[i].Timestamp, batch[j].Timestamp; {
+		case a.Before(b):
+			result = append(result, data[i])
+			i++
+		case b.Before(a):
+			result = append(result, batch[j])
+			changed = append(changed, batch[j])
+			j++
+		default:
+			result = append(result, batch[j])
+			if !data[i].Equal(batch[j]) {
+				changed = append(changed, batch[j])
+			}
+			i++
+			j++
+		}
+	}
+
+	result = append(result, data[i:]...)
+	result = append(result, batch[j:]...)
+	changed = append(changed, batch[j:]...)
+
+	return result, changed
+}
+
+// Trim removes elements from r.data with a timestamp older than the
+// timestamp of the newest element minus retention, or the current
+// time minus retention if that is later and the store has a clock. It
+// assumes the elements are sorted chronologically and the mutex is
+// locked.
+func (r *Store) trim() {
+	if len(r.data) == 0 {
 		return
 	}
 
-	unique := make([]*gym.Utilization, 0, len(r.data))
-	unique = append(unique, r.data[0])
+	// elements before threshold will be forgotten
+	threshold := r.newest().Add(-r.retention)
 
-	for _, d := range r.data[1:] {
-		l := len(unique) - 1 // index of the last unique element
-		if d.Timestamp.Equal(unique[l].Timestamp) {
-			// overwrite values with the same timestamp as the last one
-			unique[l] = d
-		} else {
-			// add values with different timestamps than the last one
-			unique = append(unique, d)
+	if r.clock != nil {
+		if t := r.clock().Add(-r.retention); t.After(threshold) {
+			threshold = t
 		}
 	}
 
-	r.data = unique
+	first := r.search(threshold)
+	if first == 0 {
+		return
+	}
+
+	// let the forgotten values be garbage collected, the slice will
+	// drop the rest of its old array when it grows
+	for i := 0; i < first; i++ {
+		r.data[i] = nil
+	}
+
+	r.data = r.data[first:]
 }
 
 // Get returns the most recent utilization values or an empty slice if
@@ -120,3 +292,25 @@ func (r *Store) Get(_ context.Context) ([]*gym.Utilization, error) {
 
 	return result, nil
 }
+
+// GetRange returns the values from start (inclusive) to end
+// (exclusive), or an empty slice if there are none.
+func (r *Store) GetRange(
+	_ context.Context,
+	start, end time.Time,
+) ([]*gym.Utilization, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	first := r.search(start)
+	last := r.search(end)
+
+	if last < first {
+		last = first
+	}
+
+	result := make([]*gym.Utilization, last-first)
+	copy(result, r.data[first:last])
+
+	return result, nil
+}
@@ -2,11 +2,14 @@ package recent_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 
 	"github.com/alcortesm/sputnik-popularity/app/gym"
 	"github.com/alcortesm/sputnik-popularity/app/recent"
@@ -22,6 +25,10 @@ func TestStore(t *testing.T) {
 		"forgets old values":               forgetsOldValues,
 		"overwrites values":                overwritesValues,
 		"all mixed together":               allMixed,
+		"gets ranges":                      getsRanges,
+		"overwrites and inserts at once":   overwritesAndInserts,
+		"forgets values by the clock":      forgetsByClock,
+		"sweeps in the background":         sweepsInBackground,
 	}
 
 	for name, fn := range subtests {
@@ -423,3 +430,338 @@ func allMixed(t *testing.T) {
 		t.Errorf("(-want +got)\n%s", diff)
 	}
 }
+
+func getsRanges(t *testing.T) {
+	u1 := fixValue(t, 1)
+	u2 := fixValue(t, 2)
+	u3 := fixValue(t, 3)
+	u4 := fixValue(t, 4)
+
+	ctx := context.Background() // irrelevant
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Add(ctx, u3, u1, u4, u2); err != nil {
+		t.Fatal(err)
+	}
+
+	subtests := []struct {
+		name       string
+		start, end time.Time
+		want       []*gym.Utilization
+	}{
+		{
+			name:  "everything",
+			start: u1.Timestamp.Add(-time.Hour),
+			end:   u4.Timestamp.Add(time.Hour),
+			want:  []*gym.Utilization{u1, u2, u3, u4},
+		},
+		{
+			name:  "start is inclusive, end is exclusive",
+			start: u2.Timestamp,
+			end:   u4.Timestamp,
+			want:  []*gym.Utilization{u2, u3},
+		},
+		{
+			name:  "between values",
+			start: u2.Timestamp.Add(time.Millisecond),
+			end:   u3.Timestamp,
+			want:  []*gym.Utilization{},
+		},
+		{
+			name:  "end before start",
+			start: u4.Timestamp,
+			end:   u1.Timestamp,
+			want:  []*gym.Utilization{},
+		},
+	}
+
+	for _, test := range subtests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := store.GetRange(ctx, test.start, test.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func overwritesAndInserts(t *testing.T) {
+	u1 := fixValue(t, 1)
+	u1b := fixValue(t, 1)
+	u1b.Capacity = 42
+	u2 := fixValue(t, 2)
+	u3 := fixValue(t, 3)
+	u3b := fixValue(t, 3)
+	u3b.Capacity = 42
+
+	ctx := context.Background() // irrelevant
+
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Add(ctx, u1, u3); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first value can be overwritten in place but the second one
+	// has to be inserted
+	if err := store.Add(ctx, u3b, u1b, u2); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{u1b, u2, u3b}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func forgetsByClock(t *testing.T) {
+	u1 := fixValue(t, 1)
+	u2 := fixValue(t, 2)
+	u3 := fixValue(t, 3)
+
+	ctx := context.Background() // irrelevant
+
+	var mutex sync.Mutex
+	now := u3.Timestamp
+
+	clock := func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return now
+	}
+
+	store, err := recent.NewClockStore(2*time.Second, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Add(ctx, u1, u2, u3); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(want ...*gym.Utilization) {
+		t.Helper()
+
+		got, err := store.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("(-want +got)\n%s", diff)
+		}
+	}
+
+	check(u1, u2, u3)
+
+	// time passes without new values
+	mutex.Lock()
+	now = u3.Timestamp.Add(time.Millisecond)
+	mutex.Unlock()
+
+	// nothing is forgotten until the store is swept
+	check(u1, u2, u3)
+
+	store.Sweep()
+	check(u2, u3)
+
+	mutex.Lock()
+	now = u3.Timestamp.Add(time.Hour)
+	mutex.Unlock()
+
+	store.Sweep()
+	check()
+
+	// old values are forgotten right away
+	if err := store.Add(ctx, u3); err != nil {
+		t.Fatal(err)
+	}
+
+	check()
+}
+
+func sweepsInBackground(t *testing.T) {
+	u1 := fixValue(t, 1)
+
+	var mutex sync.Mutex
+	now := u1.Timestamp
+
+	clock := func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return now
+	}
+
+	store, err := recent.NewClockStore(time.Hour, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := store.Add(ctx, u1); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- store.RunSweeper(ctx, time.Millisecond)
+	}()
+
+	mutex.Lock()
+	now = u1.Timestamp.Add(2 * time.Hour)
+	mutex.Unlock()
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, ok := store.Latest(ctx); !ok {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the value was not forgotten")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("want a cancelled error, got %v", err)
+	}
+
+	if err := store.RunSweeper(context.Background(), 0); err == nil {
+		t.Error("unexpected success with a zero interval")
+	}
+}
+
+// week returns a week of values, one per minute, and the retention to
+// keep all of them.
+func week(b *testing.B) ([]*gym.Utilization, time.Duration) {
+	b.Helper()
+
+	const n = 7 * 24 * 60
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	result := make([]*gym.Utilization, n)
+
+	for i := range result {
+		result[i] = &gym.Utilization{
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Capacity:  100,
+			People:    uint64(i % 100),
+		}
+	}
+
+	return result, n * time.Minute
+}
+
+// full returns a store with a week of values.
+func full(b *testing.B) (*recent.Store, []*gym.Utilization) {
+	b.Helper()
+
+	data, retention := week(b)
+
+	store, err := recent.NewStore(retention)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if err := store.Add(context.Background(), data...); err != nil {
+		b.Fatal(err)
+	}
+
+	return store, data
+}
+
+func BenchmarkStore_Add(b *testing.B) {
+	ctx := context.Background()
+
+	// scraped values: a newer value each time, forgetting the oldest
+	b.Run("newer value", func(b *testing.B) {
+		store, data := full(b)
+		newest := data[len(data)-1]
+
+		values := make([]*gym.Utilization, b.N)
+		for i := range values {
+			values[i] = &gym.Utilization{
+				Timestamp: newest.Timestamp.Add(time.Duration(i+1) * time.Minute),
+				Capacity:  100,
+			}
+		}
+
+		b.ResetTimer()
+
+		for _, v := range values {
+			if err := store.Add(ctx, v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// refreshing the store from the database
+	b.Run("overwrite a week", func(b *testing.B) {
+		store, data := full(b)
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if err := store.Add(ctx, data...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// values from the database that were not in the store
+	b.Run("insert older value", func(b *testing.B) {
+		store, data := full(b)
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			v := *data[i%len(data)]
+			v.Timestamp = v.Timestamp.Add(time.Second)
+
+			if err := store.Add(ctx, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStore_Get(b *testing.B) {
+	ctx := context.Background()
+	store, _ := full(b)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Get(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package recent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// SubscriptionBuffer is the number of values each subscription can
+// hold before its subscriber is considered slow.
+const SubscriptionBuffer = 256
+
+// SlowPolicy says what to do with the values for a slow subscriber,
+// one whose subscription buffer has no room for them.
+type SlowPolicy int
+
+const (
+	// DropSubscriber ends the subscription, closing its channel, so
+	// the subscriber never misses values without noticing. It can
+	// subscribe again and call Get to catch up.
+	DropSubscriber SlowPolicy = iota
+	// DropValues doesn't deliver the values that don't fit in the
+	// buffer, but keeps the subscription, for subscribers that only
+	// care about the latest values, like live charts.
+	DropValues
+)
+
+// subscriber is the state of a subscription.
+type subscriber struct {
+	ch     chan *gym.Utilization
+	policy SlowPolicy
+	// closed when the subscriber is dropped, to stop waiting for the
+	// cancellation of its context
+	dropped chan struct{}
+}
+
+// Subscribe returns a channel where the values added to the store
+// from now on are sent, if they are new or change a stored value, and
+// they are not forgotten right away because of the retention. The
+// values of each call to Add are sent chronologically, after the store
+// has been updated.
+//
+// The channel is closed when the context is cancelled, or when the
+// subscriber is dropped because it is slow, depending on the policy.
+func (r *Store) Subscribe(
+	ctx context.Context,
+	policy SlowPolicy,
+) (<-chan *gym.Utilization, error) {
+	switch policy {
+	case DropSubscriber, DropValues:
+	default:
+		return nil, fmt.Errorf("unknown slow subscriber policy %d", policy)
+	}
+
+	s := &subscriber{
+		ch:      make(chan *gym.Utilization, SubscriptionBuffer),
+		policy:  policy,
+		dropped: make(chan struct{}),
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if r.subscribers == nil {
+		r.subscribers = map[*subscriber]struct{}{}
+	}
+
+	r.subscribers[s] = struct{}{}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.dropped:
+			return
+		}
+
+		r.mux.Lock()
+		defer r.mux.Unlock()
+
+		r.unsubscribe(s)
+	}()
+
+	return s.ch, nil
+}
+
+// Unsubscribe removes a subscriber and closes its channel, if it was
+// not removed already. It assumes the mutex is locked.
+func (r *Store) unsubscribe(s *subscriber) {
+	if _, ok := r.subscribers[s]; !ok {
+		return
+	}
+
+	delete(r.subscribers, s)
+	close(s.ch)
+	close(s.dropped)
+}
+
+// Publish sends the sorted values that have changed in the store to
+// the subscribers, skipping the ones that have already been forgotten.
+// It never blocks, slow subscribers are handled by their policy. It
+// assumes the mutex is locked, so the subscribers receive the changes
+// in the same order they are made.
+func (r *Store) publish(changed []*gym.Utilization) {
+	if len(r.subscribers) == 0 || len(r.data) == 0 {
+		return
+	}
+
+	oldest := r.data[0].Timestamp
+
+	changed = changed[sort.Search(len(changed), func(i int) bool {
+		return !changed[i].Timestamp.Before(oldest)
+	}):]
+
+	if len(changed) == 0 {
+		return
+	}
+
+	for s := range r.subscribers {
+		// only Add sends to the channel, with the mutex locked, so
+		// the room can only grow until the loop below
+		room := cap(s.ch) - len(s.ch)
+
+		if s.policy == DropSubscriber && room < len(changed) {
+			r.unsubscribe(s)
+			continue
+		}
+
+		for _, d := range changed {
+			select {
+			case s.ch <- d:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package recent_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/recent"
+)
+
+func TestStore_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]func(t *testing.T){
+		"receives the changes":            receivesChanges,
+		"slow subscribers are dropped":    dropsSlowSubscribers,
+		"slow subscribers miss values":    dropsValues,
+		"cancelling closes the channel":   closesOnCancel,
+		"unknown policies are an error":   unknownPolicy,
+		"cancelled contexts are an error": cancelledContext,
+	}
+
+	for name, fn := range subtests {
+		fn := fn
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			fn(t)
+		})
+	}
+}
+
+// received returns the values in the channel, without waiting for
+// more.
+func received(ch <-chan *gym.Utilization) []*gym.Utilization {
+	result := []*gym.Utilization{}
+
+	for {
+		select {
+		case d, ok := <-ch:
+			if !ok {
+				return result
+			}
+
+			result = append(result, d)
+		default:
+			return result
+		}
+	}
+}
+
+func receivesChanges(t *testing.T) {
+	retention := 10 * time.Second
+
+	store, err := recent.NewStore(retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// before subscribing
+	if err := store.Add(ctx, fixValue(t, 5), fixValue(t, 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := store.Subscribe(ctx, recent.DropSubscriber)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overwritten := fixValue(t, 20)
+	overwritten.People = 0
+
+	err = store.Add(ctx,
+		fixValue(t, 21), // new
+		fixValue(t, 5),  // forgotten right away
+		fixValue(t, 20), // unchanged
+		fixValue(t, 15), // inserted
+		overwritten,     // overwrites the unchanged one
+		fixValue(t, 22), // new
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{
+		fixValue(t, 15),
+		overwritten,
+		fixValue(t, 21),
+		fixValue(t, 22),
+	}
+
+	if diff := cmp.Diff(want, received(ch)); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+
+	// overwriting with the same values is not a change
+	if err := store.Add(ctx, fixValue(t, 21), fixValue(t, 22)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := received(ch); len(got) != 0 {
+		t.Errorf("unexpected values: %v", got)
+	}
+}
+
+// fill adds more values than fit in a subscription buffer.
+func fill(t *testing.T, store *recent.Store) {
+	t.Helper()
+
+	data := make([]*gym.Utilization, recent.SubscriptionBuffer+1)
+	for i := range data {
+		data[i] = fixValue(t, i+1)
+	}
+
+	if err := store.Add(context.Background(), data...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dropsSlowSubscribers(t *testing.T) {
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := store.Subscribe(context.Background(), recent.DropSubscriber)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fill(t, store)
+
+	// closed without values, so no values are missed silently
+	if _, ok := <-ch; ok {
+		t.Error("the subscriber was not dropped")
+	}
+}
+
+func dropsValues(t *testing.T) {
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := store.Subscribe(context.Background(), recent.DropValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fill(t, store)
+
+	if got := len(received(ch)); got != recent.SubscriptionBuffer {
+		t.Errorf("want %d values, got %d", recent.SubscriptionBuffer, got)
+	}
+
+	// still subscribed
+	last := fixValue(t, recent.SubscriptionBuffer+2)
+
+	if err := store.Add(context.Background(), last); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]*gym.Utilization{last}, received(ch)); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func closesOnCancel(t *testing.T) {
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch, err := store.Subscribe(ctx, recent.DropSubscriber)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("unexpected value")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the channel was not closed")
+	}
+
+	// adding after the cancellation doesn't panic
+	if err := store.Add(context.Background(), fixValue(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func unknownPolicy(t *testing.T) {
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Subscribe(context.Background(), recent.SlowPolicy(42))
+	if err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func cancelledContext(t *testing.T) {
+	store, err := recent.NewStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = store.Subscribe(ctx, recent.DropSubscriber)
+	if err == nil {
+		t.Error("unexpected success")
+	}
+}
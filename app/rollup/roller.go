@@ -0,0 +1,342 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// chunkWindows is the max number of windows to compute at once, to
+// limit the raw values read from the store in each query.
+const chunkWindows = 1000
+
+// RangeGetter knows how to get the raw values in a time range, see
+// storage.Store.
+type RangeGetter interface {
+	GetRange(ctx context.Context, start, end time.Time) (
+		[]*gym.Utilization, error)
+}
+
+// Config is the configuration of a Roller.
+type Config struct {
+	// Interval is how often to compute the new rollups.
+	Interval time.Duration
+	// Lookback is how long before the last rollup to recompute the
+	// rollups of each tier, for the raw values that arrive late, like
+	// the ones in a write-ahead queue during an outage.
+	Lookback time.Duration
+	// Backfill is how long before now to compute the rollups of the
+	// tiers without rollups.
+	Backfill time.Duration
+}
+
+// Roller keeps the rollups of all the tiers up to date with the raw
+// values, computing them incrementally: each run only computes the
+// windows completed since the last one, plus the lookback.
+type Roller struct {
+	logger *log.Logger
+	raw    RangeGetter
+	store  Store
+	config Config
+	clock  func() time.Time
+}
+
+// NewRoller returns a roller that reads raw values from raw and stores
+// the rollups in store, which can be the same store.
+func NewRoller(
+	logger *log.Logger,
+	raw RangeGetter,
+	store Store,
+	config Config,
+	clock func() time.Time,
+) (*Roller, error) {
+	switch {
+	case config.Interval <= 0:
+		return nil, fmt.Errorf("interval must be >0, was %v",
+			config.Interval)
+	case config.Lookback < 0:
+		return nil, fmt.Errorf("lookback must be >=0, was %v",
+			config.Lookback)
+	case config.Backfill < 0:
+		return nil, fmt.Errorf("backfill must be >=0, was %v",
+			config.Backfill)
+	}
+
+	return &Roller{
+		logger: logger,
+		raw:    raw,
+		store:  store,
+		config: config,
+		clock:  clock,
+	}, nil
+}
+
+// Run computes the rollups every interval until the context is
+// cancelled, and returns its error. Failed runs are logged and retried
+// in the next interval.
+func (r *Roller) Run(ctx context.Context) error {
+	const prefix = "rollups"
+
+	for {
+		if err := r.RollUp(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Printf("%s: %v", prefix, err)
+		}
+
+		t := time.NewTimer(r.config.Interval)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// RollUp computes the rollups of all the tiers completed since their
+// last rollups.
+func (r *Roller) RollUp(ctx context.Context) error {
+	for _, tier := range Tiers {
+		if err := r.rollUp(ctx, tier); err != nil {
+			return fmt.Errorf("tier %s: %v", tier, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Roller) rollUp(ctx context.Context, tier Tier) error {
+	window := tier.Window()
+
+	// only complete windows
+	end := storage.WindowStart(r.clock(), window)
+
+	last, ok, err := r.store.LastRollup(ctx, tier)
+	if err != nil {
+		return fmt.Errorf("getting last rollup: %v", err)
+	}
+
+	start := end.Add(-r.config.Backfill)
+	if ok {
+		start = last.Add(window).Add(-r.config.Lookback)
+	}
+
+	return r.rollUpRange(ctx, tier, storage.WindowStart(start, window), end)
+}
+
+// RollUpRange recomputes the rollups of all the tiers for the complete
+// windows with values from start to end, like after importing values
+// in that range.
+//
+// Tiers without rollups yet are skipped, RollUp computes their first
+// rollups. As the values before the first rollup of a tier are read
+// from the raw values, see Aggregated, the range is extended up to the
+// first rollup, so no window is left without its rollups.
+func (r *Roller) RollUpRange(ctx context.Context, start, end time.Time) error {
+	for _, tier := range Tiers {
+		window := tier.Window()
+
+		first, ok, err := r.store.FirstRollup(ctx, tier)
+		if err != nil {
+			return fmt.Errorf("tier %s: getting first rollup: %v", tier, err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		// the whole windows of the range
+		to := storage.WindowStart(end, window)
+		if to.Before(end) {
+			to = to.Add(window)
+		}
+
+		if first.After(to) {
+			to = first
+		}
+
+		// only complete windows
+		if now := storage.WindowStart(r.clock(), window); to.After(now) {
+			to = now
+		}
+
+		from := storage.WindowStart(start, window)
+
+		if err := r.rollUpRange(ctx, tier, from, to); err != nil {
+			return fmt.Errorf("tier %s: %v", tier, err)
+		}
+	}
+
+	return nil
+}
+
+// rollUpRange computes and stores the rollups of a tier from start to
+// end, which must be at the start of windows.
+func (r *Roller) rollUpRange(
+	ctx context.Context,
+	tier Tier,
+	start, end time.Time,
+) error {
+	return forChunks(start, end, tier.Window(),
+		func(start, end time.Time) error {
+			data, err := r.raw.GetRange(ctx, start, end)
+			if err != nil {
+				return fmt.Errorf("getting raw values: %v", err)
+			}
+
+			rollups := Compute(data, tier.Window())
+			if len(rollups) == 0 {
+				return nil
+			}
+
+			if err := r.store.AddRollups(ctx, tier, rollups...); err != nil {
+				return fmt.Errorf("adding rollups: %v", err)
+			}
+
+			return nil
+		})
+}
+
+// forChunks calls fn with consecutive ranges from start to end, of up
+// to chunkWindows windows each, so the raw values of each range can be
+// read at once. The ranges end at the start of a window, except the
+// last one.
+func forChunks(
+	start, end time.Time,
+	window time.Duration,
+	fn func(start, end time.Time) error,
+) error {
+	for start.Before(end) {
+		chunkEnd := storage.WindowStart(start, window).
+			Add(chunkWindows * window)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+
+		if err := fn(start, chunkEnd); err != nil {
+			return err
+		}
+
+		start = chunkEnd
+	}
+
+	return nil
+}
+
+// computeRaw returns the rollups of the raw values from start to end,
+// reading them in chunks, so only the rollups are kept in memory.
+func computeRaw(
+	ctx context.Context,
+	raw RangeGetter,
+	tier Tier,
+	start, end time.Time,
+) ([]*Rollup, error) {
+	result := []*Rollup{}
+
+	err := forChunks(start, end, tier.Window(),
+		func(start, end time.Time) error {
+			data, err := raw.GetRange(ctx, start, end)
+			if err != nil {
+				return fmt.Errorf("getting raw values: %v", err)
+			}
+
+			result = append(result, Compute(data, tier.Window())...)
+
+			return nil
+		})
+
+	return result, err
+}
+
+// Aggregated returns the values from start (inclusive) to end
+// (exclusive) aggregated in windows, like
+// storage.Aggregator.GetAggregated, using the rollups of a tier
+// from its first to its last rollup, and the raw values before and
+// after them, like the values older than the backfill of the roller or
+// the ones not rolled up yet. The window must be a multiple of the
+// window of the tier.
+func Aggregated(
+	ctx context.Context,
+	store Store,
+	raw RangeGetter,
+	tier Tier,
+	start, end time.Time,
+	window time.Duration,
+	fn storage.Aggregate,
+) ([]*gym.Utilization, error) {
+	if window <= 0 || window%tier.Window() != 0 {
+		return nil, fmt.Errorf("invalid window %v: must be a multiple "+
+			"of %v", window, tier.Window())
+	}
+
+	first, hasFirst, err := store.FirstRollup(ctx, tier)
+	if err != nil {
+		return nil, fmt.Errorf("getting first rollup: %v", err)
+	}
+
+	last, hasLast, err := store.LastRollup(ctx, tier)
+	if err != nil {
+		return nil, fmt.Errorf("getting last rollup: %v", err)
+	}
+
+	// the whole windows of the tier in the range that are covered by
+	// the rollups
+	from, to := start, start
+	if hasFirst && hasLast {
+		from = first
+		if from.Before(start) {
+			from = storage.WindowStart(start, tier.Window())
+			if from.Before(start) {
+				from = from.Add(tier.Window())
+			}
+		}
+
+		if from.After(end) {
+			from = end
+		}
+
+		to = last.Add(tier.Window())
+		if e := storage.WindowStart(end, tier.Window()); to.After(e) {
+			to = e
+		}
+
+		if to.Before(from) {
+			to = from
+		}
+	}
+
+	rollups := []*Rollup{}
+
+	if start.Before(from) {
+		computed, err := computeRaw(ctx, raw, tier, start, from)
+		if err != nil {
+			return nil, err
+		}
+
+		rollups = append(rollups, computed...)
+	}
+
+	if from.Before(to) {
+		stored, err := store.GetRollups(ctx, tier, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("getting rollups: %v", err)
+		}
+
+		rollups = append(rollups, stored...)
+	}
+
+	if to.Before(end) {
+		computed, err := computeRaw(ctx, raw, tier, to, end)
+		if err != nil {
+			return nil, err
+		}
+
+		rollups = append(rollups, computed...)
+	}
+
+	return Combine(rollups, window, fn)
+}
@@ -0,0 +1,246 @@
+// Package rollup summarizes the gym utilization data in hourly and
+// daily windows, so long time ranges can be read without reading all
+// their values.
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// Tier is a level of rollups, by the duration of their windows.
+type Tier string
+
+// The supported tiers.
+const (
+	Hourly Tier = "1h"
+	Daily  Tier = "1d"
+)
+
+// Tiers are the supported tiers, from shortest to longest window.
+var Tiers = []Tier{Hourly, Daily}
+
+// Window returns the duration of the windows of the tier.
+func (t Tier) Window() time.Duration {
+	switch t {
+	case Hourly:
+		return time.Hour
+	case Daily:
+		return 24 * time.Hour
+	default:
+		panic(fmt.Sprintf("unknown tier %q", string(t)))
+	}
+}
+
+// Stats summarizes a quantity in a window.
+type Stats struct {
+	Mean float64
+	Min  uint64
+	Max  uint64
+}
+
+// Rollup summarizes the values of a gym in a time window.
+type Rollup struct {
+	Gym      string
+	Start    time.Time // the start of the window, in UTC
+	Count    int       // the number of values in the window
+	People   Stats
+	Capacity Stats
+}
+
+// Store is a store of rollups.
+type Store interface {
+	// AddRollups adds rollups to a tier, overwriting the ones with the
+	// same gym and start.
+	AddRollups(ctx context.Context, tier Tier, rollups ...*Rollup) error
+	// GetRollups returns the rollups of a tier with their start from
+	// start (inclusive) to end (exclusive), sorted by gym and then
+	// chronologically.
+	GetRollups(ctx context.Context, tier Tier, start, end time.Time) (
+		[]*Rollup, error)
+	// FirstRollup returns the start of the first rollup of a tier, for
+	// any gym, and false if the tier has no rollups.
+	FirstRollup(ctx context.Context, tier Tier) (time.Time, bool, error)
+	// LastRollup returns the start of the last rollup of a tier, for
+	// any gym, and false if the tier has no rollups.
+	LastRollup(ctx context.Context, tier Tier) (time.Time, bool, error)
+}
+
+// Compute returns the rollups of the values in windows of the given
+// duration, aligned to the Unix epoch, sorted by gym and then
+// chronologically.
+func Compute(data []*gym.Utilization, window time.Duration) []*Rollup {
+	type key struct {
+		gym   string
+		start int64
+	}
+
+	keys := []key{}
+	groups := map[key]*Rollup{}
+
+	for _, d := range data {
+		start := storage.WindowStart(d.Timestamp, window)
+		k := key{gym: d.Gym, start: start.UnixNano()}
+
+		r, ok := groups[k]
+		if !ok {
+			keys = append(keys, k)
+
+			r = &Rollup{
+				Gym:      d.Gym,
+				Start:    start,
+				People:   Stats{Min: d.People, Max: d.People},
+				Capacity: Stats{Min: d.Capacity, Max: d.Capacity},
+			}
+			groups[k] = r
+		}
+
+		r.Count++
+		r.People.add(d.People, r.Count)
+		r.Capacity.add(d.Capacity, r.Count)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].gym != keys[j].gym {
+			return keys[i].gym < keys[j].gym
+		}
+
+		return keys[i].start < keys[j].start
+	})
+
+	result := make([]*Rollup, len(keys))
+	for i, k := range keys {
+		result[i] = groups[k]
+	}
+
+	return result
+}
+
+// add adds the nth value to the stats.
+func (s *Stats) add(v uint64, n int) {
+	s.Mean += (float64(v) - s.Mean) / float64(n)
+
+	if v < s.Min {
+		s.Min = v
+	}
+
+	if v > s.Max {
+		s.Max = v
+	}
+}
+
+// Combine aggregates rollups in longer windows with the given
+// function, like storage.Aggregator.GetAggregated, the window must be a
+// multiple of the windows of the rollups. Means are weighted by the
+// number of values of each rollup, and rounded to the nearest integer.
+//
+// The last function is not supported, as rollups don't know the last
+// value of their windows.
+func Combine(
+	rollups []*Rollup,
+	window time.Duration,
+	fn storage.Aggregate,
+) ([]*gym.Utilization, error) {
+	if fn == storage.Last {
+		return nil, fmt.Errorf("rollups don't support the %s function",
+			string(fn))
+	}
+
+	if err := fn.Validate(); err != nil {
+		return nil, err
+	}
+
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid window %v: must be >0", window)
+	}
+
+	combined := []*Rollup{}
+	var last *Rollup
+
+	for _, r := range sorted(rollups) {
+		start := storage.WindowStart(r.Start, window)
+
+		if last == nil || last.Gym != r.Gym || !last.Start.Equal(start) {
+			c := *r
+			c.Start = start
+			combined = append(combined, &c)
+			last = &c
+
+			continue
+		}
+
+		n := float64(last.Count + r.Count)
+		last.People = last.People.merge(r.People,
+			float64(last.Count)/n, float64(r.Count)/n)
+		last.Capacity = last.Capacity.merge(r.Capacity,
+			float64(last.Count)/n, float64(r.Count)/n)
+		last.Count += r.Count
+	}
+
+	result := make([]*gym.Utilization, len(combined))
+
+	for i, c := range combined {
+		result[i] = &gym.Utilization{
+			Gym:       c.Gym,
+			Timestamp: c.Start,
+			People:    c.People.value(fn),
+			Capacity:  c.Capacity.value(fn),
+		}
+	}
+
+	return result, nil
+}
+
+// sorted returns a copy of the rollups sorted by gym and then
+// chronologically.
+func sorted(rollups []*Rollup) []*Rollup {
+	result := make([]*Rollup, len(rollups))
+	copy(result, rollups)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Gym != result[j].Gym {
+			return result[i].Gym < result[j].Gym
+		}
+
+		return result[i].Start.Before(result[j].Start)
+	})
+
+	return result
+}
+
+// merge returns the stats of two windows with the given weights for
+// their means.
+func (s Stats) merge(o Stats, weight, otherWeight float64) Stats {
+	result := Stats{
+		Mean: s.Mean*weight + o.Mean*otherWeight,
+		Min:  s.Min,
+		Max:  s.Max,
+	}
+
+	if o.Min < result.Min {
+		result.Min = o.Min
+	}
+
+	if o.Max > result.Max {
+		result.Max = o.Max
+	}
+
+	return result
+}
+
+func (s Stats) value(fn storage.Aggregate) uint64 {
+	switch fn {
+	case storage.Max:
+		return s.Max
+	case storage.Min:
+		return s.Min
+	default:
+		return uint64(math.Round(s.Mean))
+	}
+}
@@ -0,0 +1,553 @@
+package rollup_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/rollup"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+// day1 is 2020-10-01 at midnight.
+var day1 = time.Date(2020, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return day1.Add(time.Duration(minutes) * time.Minute)
+}
+
+func logger(t *testing.T) *log.Logger {
+	return log.New(ioutil.Discard, t.Name(), 0)
+}
+
+// rawStore is a rollup.RangeGetter that keeps its values in a slice.
+type rawStore struct {
+	mutex sync.Mutex
+	data  []*gym.Utilization
+}
+
+func (s *rawStore) add(data ...*gym.Utilization) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data = append(s.data, data...)
+}
+
+func (s *rawStore) GetRange(
+	_ context.Context,
+	start, end time.Time,
+) ([]*gym.Utilization, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := []*gym.Utilization{}
+
+	for _, d := range s.data {
+		if !d.Timestamp.Before(start) && d.Timestamp.Before(end) {
+			result = append(result, d)
+		}
+	}
+
+	return result, nil
+}
+
+// rollupStore is a rollup.Store in memory.
+type rollupStore struct {
+	mutex sync.Mutex
+	tiers map[rollup.Tier]map[string]*rollup.Rollup // by gym and start
+}
+
+func newRollupStore() *rollupStore {
+	return &rollupStore{
+		tiers: map[rollup.Tier]map[string]*rollup.Rollup{},
+	}
+}
+
+func (s *rollupStore) AddRollups(
+	_ context.Context,
+	tier rollup.Tier,
+	rollups ...*rollup.Rollup,
+) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tiers[tier] == nil {
+		s.tiers[tier] = map[string]*rollup.Rollup{}
+	}
+
+	for _, r := range rollups {
+		s.tiers[tier][r.Gym+r.Start.Format(time.RFC3339)] = r
+	}
+
+	return nil
+}
+
+func (s *rollupStore) GetRollups(
+	_ context.Context,
+	tier rollup.Tier,
+	start, end time.Time,
+) ([]*rollup.Rollup, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := []*rollup.Rollup{}
+
+	for _, r := range s.tiers[tier] {
+		if !r.Start.Before(start) && r.Start.Before(end) {
+			result = append(result, r)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Gym != result[j].Gym {
+			return result[i].Gym < result[j].Gym
+		}
+
+		return result[i].Start.Before(result[j].Start)
+	})
+
+	return result, nil
+}
+
+func (s *rollupStore) FirstRollup(
+	_ context.Context,
+	tier rollup.Tier,
+) (time.Time, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var first time.Time
+	ok := false
+
+	for _, r := range s.tiers[tier] {
+		if !ok || r.Start.Before(first) {
+			first, ok = r.Start, true
+		}
+	}
+
+	return first, ok, nil
+}
+
+func (s *rollupStore) LastRollup(
+	_ context.Context,
+	tier rollup.Tier,
+) (time.Time, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var last time.Time
+	ok := false
+
+	for _, r := range s.tiers[tier] {
+		if !ok || r.Start.After(last) {
+			last, ok = r.Start, true
+		}
+	}
+
+	return last, ok, nil
+}
+
+func TestCompute(t *testing.T) {
+	t.Parallel()
+
+	data := []*gym.Utilization{
+		{Gym: "b", Timestamp: at(5), People: 7, Capacity: 20},
+		{Gym: "a", Timestamp: at(10), People: 1, Capacity: 10},
+		{Gym: "a", Timestamp: at(20), People: 4, Capacity: 10},
+		{Gym: "a", Timestamp: at(50), People: 2, Capacity: 12},
+		{Gym: "a", Timestamp: at(70), People: 5, Capacity: 10},
+	}
+
+	want := []*rollup.Rollup{
+		{
+			Gym:      "a",
+			Start:    at(0),
+			Count:    3,
+			People:   rollup.Stats{Mean: 7.0 / 3, Min: 1, Max: 4},
+			Capacity: rollup.Stats{Mean: 32.0 / 3, Min: 10, Max: 12},
+		},
+		{
+			Gym:      "a",
+			Start:    at(60),
+			Count:    1,
+			People:   rollup.Stats{Mean: 5, Min: 5, Max: 5},
+			Capacity: rollup.Stats{Mean: 10, Min: 10, Max: 10},
+		},
+		{
+			Gym:      "b",
+			Start:    at(0),
+			Count:    1,
+			People:   rollup.Stats{Mean: 7, Min: 7, Max: 7},
+			Capacity: rollup.Stats{Mean: 20, Min: 20, Max: 20},
+		},
+	}
+
+	got := rollup.Compute(data, time.Hour)
+
+	if diff := cmp.Diff(want, got, approx()); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+// approx compares floats with some tolerance for rounding errors.
+func approx() cmp.Option {
+	return cmp.Comparer(func(a, b float64) bool {
+		d := a - b
+		return d < 1e-9 && d > -1e-9
+	})
+}
+
+func TestCombine(t *testing.T) {
+	t.Parallel()
+
+	rollups := []*rollup.Rollup{
+		{
+			Gym:      "a",
+			Start:    at(60),
+			Count:    3,
+			People:   rollup.Stats{Mean: 2, Min: 1, Max: 3},
+			Capacity: rollup.Stats{Mean: 10, Min: 10, Max: 10},
+		},
+		{
+			Gym:      "a",
+			Start:    at(0),
+			Count:    1,
+			People:   rollup.Stats{Mean: 10, Min: 10, Max: 10},
+			Capacity: rollup.Stats{Mean: 14, Min: 14, Max: 14},
+		},
+		{
+			Gym:      "a",
+			Start:    at(180),
+			Count:    1,
+			People:   rollup.Stats{Mean: 1, Min: 1, Max: 1},
+			Capacity: rollup.Stats{Mean: 10, Min: 10, Max: 10},
+		},
+	}
+
+	subtests := map[storage.Aggregate][]*gym.Utilization{
+		// the means are weighted by the count
+		storage.Mean: {
+			{Gym: "a", Timestamp: at(0), People: 4, Capacity: 11},
+			{Gym: "a", Timestamp: at(120), People: 1, Capacity: 10},
+		},
+		storage.Max: {
+			{Gym: "a", Timestamp: at(0), People: 10, Capacity: 14},
+			{Gym: "a", Timestamp: at(120), People: 1, Capacity: 10},
+		},
+		storage.Min: {
+			{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+			{Gym: "a", Timestamp: at(120), People: 1, Capacity: 10},
+		},
+	}
+
+	for fn, want := range subtests {
+		fn, want := fn, want
+
+		t.Run(string(fn), func(t *testing.T) {
+			t.Parallel()
+
+			got, err := rollup.Combine(rollups, 2*time.Hour, fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("last is not supported", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := rollup.Combine(rollups, 2*time.Hour, storage.Last)
+		if err == nil {
+			t.Error("unexpected success")
+		}
+	})
+}
+
+func TestRoller(t *testing.T) {
+	t.Parallel()
+
+	raw := &rawStore{}
+	raw.add(
+		&gym.Utilization{Gym: "a", Timestamp: at(10), People: 1, Capacity: 10},
+		&gym.Utilization{Gym: "a", Timestamp: at(70), People: 3, Capacity: 10},
+		// in an hour that is not complete yet
+		&gym.Utilization{Gym: "a", Timestamp: at(130), People: 5, Capacity: 10},
+	)
+
+	store := newRollupStore()
+	now := at(150)
+
+	config := rollup.Config{
+		Interval: time.Minute,
+		Lookback: time.Hour,
+		Backfill: 24 * time.Hour,
+	}
+
+	roller, err := rollup.NewRoller(logger(t), raw, store, config,
+		func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	if err := roller.RollUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	hourly, err := store.GetRollups(ctx, rollup.Hourly, at(0), at(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	starts := func(rollups []*rollup.Rollup) []time.Time {
+		result := make([]time.Time, len(rollups))
+		for i, r := range rollups {
+			result[i] = r.Start
+		}
+
+		return result
+	}
+
+	if diff := cmp.Diff([]time.Time{at(0), at(60)}, starts(hourly)); diff != "" {
+		t.Errorf("hourly: (-want +got)\n%s", diff)
+	}
+
+	// the day is not complete yet
+	if _, ok, _ := store.LastRollup(ctx, rollup.Daily); ok {
+		t.Error("unexpected daily rollups")
+	}
+
+	// a late value, within the lookback, and a new hour
+	raw.add(&gym.Utilization{Gym: "a", Timestamp: at(80), People: 5, Capacity: 10})
+	now = at(190)
+
+	if err := roller.RollUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	hourly, err = store.GetRollups(ctx, rollup.Hourly, at(0), at(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]time.Time{at(0), at(60), at(120)}, starts(hourly)); diff != "" {
+		t.Errorf("hourly after update: (-want +got)\n%s", diff)
+	}
+
+	if got := hourly[1].Count; got != 2 {
+		t.Errorf("late value not rolled up: want count 2, got %d", got)
+	}
+}
+
+func TestRoller_RollUpRange(t *testing.T) {
+	t.Parallel()
+
+	raw := &rawStore{}
+	raw.add(&gym.Utilization{Gym: "a", Timestamp: at(130), People: 5, Capacity: 10})
+
+	store := newRollupStore()
+
+	// only the last hour is backfilled
+	config := rollup.Config{
+		Interval: time.Minute,
+		Lookback: time.Hour,
+		Backfill: time.Hour,
+	}
+
+	roller, err := rollup.NewRoller(logger(t), raw, store, config,
+		func() time.Time { return at(190) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	if err := roller.RollUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// imported values, before the first rollup
+	raw.add(
+		&gym.Utilization{Gym: "b", Timestamp: at(10), People: 1, Capacity: 10},
+		&gym.Utilization{Gym: "b", Timestamp: at(15), People: 3, Capacity: 10},
+	)
+
+	// and some values between them and the first rollup, that were
+	// not imported but were older than the backfill
+	raw.add(&gym.Utilization{Gym: "a", Timestamp: at(70), People: 2, Capacity: 10})
+
+	if err := roller.RollUpRange(ctx, at(10), at(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetRollups(ctx, rollup.Hourly, at(0), at(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*rollup.Rollup{
+		{
+			Gym:      "a",
+			Start:    at(60),
+			Count:    1,
+			People:   rollup.Stats{Mean: 2, Min: 2, Max: 2},
+			Capacity: rollup.Stats{Mean: 10, Min: 10, Max: 10},
+		},
+		{
+			Gym:      "a",
+			Start:    at(120),
+			Count:    1,
+			People:   rollup.Stats{Mean: 5, Min: 5, Max: 5},
+			Capacity: rollup.Stats{Mean: 10, Min: 10, Max: 10},
+		},
+		{
+			Gym:      "b",
+			Start:    at(0),
+			Count:    2,
+			People:   rollup.Stats{Mean: 2, Min: 1, Max: 3},
+			Capacity: rollup.Stats{Mean: 10, Min: 10, Max: 10},
+		},
+	}
+
+	if diff := cmp.Diff(want, got, approx()); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+
+	// tiers without rollups are left for RollUp
+	if _, ok, _ := store.LastRollup(ctx, rollup.Daily); ok {
+		t.Error("unexpected daily rollups")
+	}
+}
+
+func TestAggregated(t *testing.T) {
+	t.Parallel()
+
+	raw := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(10), People: 1, Capacity: 10},
+		{Gym: "a", Timestamp: at(70), People: 3, Capacity: 10},
+		{Gym: "a", Timestamp: at(130), People: 5, Capacity: 10},
+	}
+
+	// rollups with values that are not in the raw values, to tell
+	// where the results come from
+	rollupAt := func(minutes int, people uint64) *rollup.Rollup {
+		return &rollup.Rollup{
+			Gym:      "a",
+			Start:    at(minutes),
+			Count:    1,
+			People:   rollup.Stats{Mean: float64(people), Min: people, Max: people},
+			Capacity: rollup.Stats{Mean: 10, Min: 10, Max: 10},
+		}
+	}
+
+	subtests := map[string]struct {
+		rollups    []*rollup.Rollup
+		start, end time.Time
+		want       []*gym.Utilization
+	}{
+		"raw values after the last rollup": {
+			rollups: []*rollup.Rollup{rollupAt(0, 2)},
+			start:   at(0),
+			end:     at(180),
+			want: []*gym.Utilization{
+				{Gym: "a", Timestamp: at(0), People: 2, Capacity: 10},
+				{Gym: "a", Timestamp: at(60), People: 3, Capacity: 10},
+				{Gym: "a", Timestamp: at(120), People: 5, Capacity: 10},
+			},
+		},
+		"raw values before the first rollup": {
+			rollups: []*rollup.Rollup{rollupAt(120, 6)},
+			start:   at(0),
+			end:     at(180),
+			want: []*gym.Utilization{
+				{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+				{Gym: "a", Timestamp: at(60), People: 3, Capacity: 10},
+				{Gym: "a", Timestamp: at(120), People: 6, Capacity: 10},
+			},
+		},
+		"no rollups": {
+			start: at(0),
+			end:   at(180),
+			want: []*gym.Utilization{
+				{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+				{Gym: "a", Timestamp: at(60), People: 3, Capacity: 10},
+				{Gym: "a", Timestamp: at(120), People: 5, Capacity: 10},
+			},
+		},
+		"range in the middle of windows": {
+			rollups: []*rollup.Rollup{
+				rollupAt(0, 2), rollupAt(60, 4), rollupAt(120, 6),
+			},
+			// only part of the first and last hours, from the raw
+			// values
+			start: at(5),
+			end:   at(135),
+			want: []*gym.Utilization{
+				{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+				{Gym: "a", Timestamp: at(60), People: 4, Capacity: 10},
+				{Gym: "a", Timestamp: at(120), People: 5, Capacity: 10},
+			},
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rawStore := &rawStore{}
+			rawStore.add(raw...)
+
+			store := newRollupStore()
+			ctx := context.Background()
+
+			if len(test.rollups) != 0 {
+				err := store.AddRollups(ctx, rollup.Hourly, test.rollups...)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := rollup.Aggregated(ctx, store, rawStore,
+				rollup.Hourly, test.start, test.end, time.Hour, storage.Max)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewRoller_InvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	configs := map[string]rollup.Config{
+		"zero interval":     {Interval: 0},
+		"negative lookback": {Interval: time.Minute, Lookback: -1},
+		"negative backfill": {Interval: time.Minute, Backfill: -1},
+	}
+
+	for name, c := range configs {
+		_, err := rollup.NewRoller(logger(t), &rawStore{}, newRollupStore(),
+			c, time.Now)
+		if err == nil {
+			t.Errorf("%s: unexpected success", name)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package schedule
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// AdaptiveConfig controls how an adaptive ticker changes its period.
+type AdaptiveConfig struct {
+	MinPeriod time.Duration
+	MaxPeriod time.Duration
+	// TargetChange is how much the utilization percent should change
+	// between ticks, in percentage points.
+	TargetChange float64
+}
+
+// Adaptive is a ticker whose period adapts to how fast the utilization
+// of a gym changes: it ticks more often when consecutive values change
+// quickly and less often when they are flat, trying to observe a
+// change of about the target change between ticks.
+//
+// The period never goes beyond the configured bounds, and it never
+// changes by more than a factor of two in a single observation, to
+// avoid overreacting to noisy values.
+type Adaptive struct {
+	config  AdaptiveConfig
+	c       chan time.Time
+	changed chan struct{} // signals a change of period
+
+	mux         sync.Mutex
+	period      time.Duration
+	last        time.Time // timestamp of the last observed value
+	lastPercent float64
+}
+
+// NewAdaptive returns an adaptive ticker that starts ticking at the
+// given period (clamped to the configured bounds) and stops when the
+// context is done.
+func NewAdaptive(
+	ctx context.Context,
+	config AdaptiveConfig,
+	initial time.Duration,
+) *Adaptive {
+	a := &Adaptive{
+		config:  config,
+		c:       make(chan time.Time),
+		changed: make(chan struct{}, 1),
+		period:  clamp(initial, config.MinPeriod, config.MaxPeriod),
+	}
+
+	go a.run(ctx)
+
+	return a
+}
+
+// C returns the channel where the ticks are delivered.
+func (a *Adaptive) C() <-chan time.Time {
+	return a.c
+}
+
+// Period returns the current period of the ticker.
+func (a *Adaptive) Period() time.Duration {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.period
+}
+
+// Observe updates the period of the ticker with a new utilization
+// value. Values are expected in chronological order; older values and
+// values without capacity are ignored.
+func (a *Adaptive) Observe(u *gym.Utilization) {
+	percent, ok := u.Percent()
+	if !ok {
+		return
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if !a.last.IsZero() {
+		elapsed := u.Timestamp.Sub(a.last)
+		if elapsed <= 0 {
+			return
+		}
+
+		change := math.Abs(percent - a.lastPercent)
+		a.period = a.next(change, elapsed)
+
+		select {
+		case a.changed <- struct{}{}:
+		default: // a change is already pending
+		}
+	}
+
+	a.last = u.Timestamp
+	a.lastPercent = percent
+}
+
+// Next returns the new period after observing a change of the given
+// percentage points in the given elapsed time. It assumes the mutex is
+// locked.
+func (a *Adaptive) next(change float64, elapsed time.Duration) time.Duration {
+	const maxFactor = 2.0
+
+	factor := maxFactor
+
+	if change > 0 {
+		// the period that would have observed the target change at the
+		// current rate of change
+		ideal := a.config.TargetChange / change * float64(elapsed)
+		factor = math.Max(1/maxFactor, math.Min(maxFactor,
+			ideal/float64(a.period)))
+	}
+
+	period := time.Duration(float64(a.period) * factor)
+
+	return clamp(period, a.config.MinPeriod, a.config.MaxPeriod)
+}
+
+func clamp(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
+
+func (a *Adaptive) run(ctx context.Context) {
+	last := time.Now()
+	timer := time.NewTimer(a.Period())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.changed:
+			// reschedule the next tick with the new period
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+
+			wait := time.Until(last.Add(a.Period()))
+			if wait < 0 {
+				wait = 0
+			}
+
+			timer.Reset(wait)
+		case now := <-timer.C:
+			select {
+			case a.c <- now:
+			case <-ctx.Done():
+				return
+			}
+
+			last = now
+			timer.Reset(a.Period())
+		}
+	}
+}
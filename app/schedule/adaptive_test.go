@@ -0,0 +1,134 @@
+package schedule_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/schedule"
+)
+
+func TestAdaptive_Period(t *testing.T) {
+	t.Parallel()
+
+	config := schedule.AdaptiveConfig{
+		MinPeriod:    time.Minute,
+		MaxPeriod:    time.Hour,
+		TargetChange: 5,
+	}
+
+	start := time.Date(2020, time.January, 1, 18, 0, 0, 0, time.UTC)
+
+	// value returns a utilization with the given percent, some minutes
+	// after the start.
+	value := func(minutes int, percent uint64) *gym.Utilization {
+		return &gym.Utilization{
+			Timestamp: start.Add(time.Duration(minutes) * time.Minute),
+			People:    percent,
+			Capacity:  100,
+		}
+	}
+
+	subtests := []struct {
+		name   string
+		values []*gym.Utilization
+		want   time.Duration
+	}{
+		{
+			name:   "no observations",
+			values: nil,
+			want:   10 * time.Minute,
+		}, {
+			name:   "a single observation",
+			values: []*gym.Utilization{value(0, 10)},
+			want:   10 * time.Minute,
+		}, {
+			name:   "changing at the target rate",
+			values: []*gym.Utilization{value(0, 10), value(10, 15)},
+			want:   10 * time.Minute,
+		}, {
+			name:   "changing a bit faster than the target rate",
+			values: []*gym.Utilization{value(0, 10), value(10, 20)},
+			want:   5 * time.Minute,
+		}, {
+			name:   "changing a lot faster, only halves the period",
+			values: []*gym.Utilization{value(0, 10), value(10, 90)},
+			want:   5 * time.Minute,
+		}, {
+			name:   "flat values, doubles the period",
+			values: []*gym.Utilization{value(0, 10), value(10, 10)},
+			want:   20 * time.Minute,
+		}, {
+			name: "never below the min period",
+			values: []*gym.Utilization{
+				value(0, 0), value(10, 50), value(20, 0),
+				value(30, 50), value(40, 0), value(50, 50),
+			},
+			want: time.Minute,
+		}, {
+			name: "never above the max period",
+			values: []*gym.Utilization{
+				value(0, 10), value(10, 10), value(20, 10),
+				value(30, 10), value(40, 10), value(50, 10),
+			},
+			want: time.Hour,
+		}, {
+			name:   "ignores old values",
+			values: []*gym.Utilization{value(10, 10), value(0, 90)},
+			want:   10 * time.Minute,
+		},
+	}
+
+	for _, test := range subtests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			a := schedule.NewAdaptive(ctx, config, 10*time.Minute)
+
+			for _, v := range test.values {
+				a.Observe(v)
+			}
+
+			if got := a.Period(); got != test.want {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestAdaptive_Ticks(t *testing.T) {
+	t.Parallel()
+
+	config := schedule.AdaptiveConfig{
+		MinPeriod:    time.Millisecond,
+		MaxPeriod:    time.Hour,
+		TargetChange: 5,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := schedule.NewAdaptive(ctx, config, time.Hour)
+
+	// fast changes will shorten the period
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		a.Observe(&gym.Utilization{
+			Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+			People:    uint64(i%2) * 100,
+			Capacity:  100,
+		})
+	}
+
+	select {
+	case <-a.C():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no tick received, period is %v", a.Period())
+	}
+}
@@ -0,0 +1,43 @@
+package schedule
+
+import "time"
+
+// Gate decides when to scrape a gym according to its schedule: always
+// while the gym is open, but only once every closed period while it is
+// closed, or never if the closed period is zero.
+//
+// Gates are not safe for concurrent use.
+type Gate struct {
+	schedule     *Schedule
+	closedPeriod time.Duration
+	last         time.Time // last allowed scrape while closed
+}
+
+// NewGate returns a gate for the given schedule and closed period.
+func NewGate(schedule *Schedule, closedPeriod time.Duration) *Gate {
+	return &Gate{
+		schedule:     schedule,
+		closedPeriod: closedPeriod,
+	}
+}
+
+// Allow returns if a scrape should happen at the given time.
+func (g *Gate) Allow(now time.Time) bool {
+	if g.schedule.IsOpen(now) {
+		// scrape as soon as the gym closes
+		g.last = time.Time{}
+		return true
+	}
+
+	if g.closedPeriod <= 0 {
+		return false
+	}
+
+	if !g.last.IsZero() && now.Sub(g.last) < g.closedPeriod {
+		return false
+	}
+
+	g.last = now
+
+	return true
+}
@@ -0,0 +1,197 @@
+// Package schedule knows about the opening hours of gyms.
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schedule is the opening hours of a gym for each day of the week, in a
+// certain timezone. A nil schedule is always open.
+type Schedule struct {
+	location *time.Location
+	hours    [7]*hours // indexed by time.Weekday, nil if closed all day
+}
+
+// Hours is when a gym opens and closes on a given day, in minutes since
+// midnight. Closing times can go past midnight for gyms that close the
+// day after.
+type hours struct {
+	open  int
+	close int
+}
+
+// Interval is a period of time from Start (inclusive) to End
+// (exclusive).
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Parse returns the schedule for the given timezone (like
+// "Europe/Amsterdam") and opening hours. The opening hours are indexed
+// by the first three letters of the weekday in English ("mon", "tue"...)
+// and look like "09:00-23:00". Closing times earlier than opening times
+// are considered to be on the next day. Days without opening hours are
+// closed all day.
+//
+// If there are no opening hours at all, it returns a nil schedule,
+// which is always open.
+func Parse(timezone string, openingHours map[string]string) (*Schedule, error) {
+	if len(openingHours) == 0 {
+		return nil, nil
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("loading timezone: %v", err)
+	}
+
+	result := &Schedule{location: location}
+
+	for day, value := range openingHours {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", day)
+		}
+
+		h, err := parseHours(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", day, err)
+		}
+
+		result.hours[weekday] = h
+	}
+
+	return result, nil
+}
+
+func parseHours(s string) (*hours, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("want HH:MM-HH:MM, got %q", s)
+	}
+
+	opening, err := parseClock(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("opening time: %v", err)
+	}
+
+	closing, err := parseClock(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("closing time: %v", err)
+	}
+
+	if closing <= opening {
+		closing += 24 * 60
+	}
+
+	return &hours{open: opening, close: closing}, nil
+}
+
+// ParseClock returns the minutes since midnight of a time like "09:30".
+// It also accepts "24:00" as the end of the day.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err == nil {
+		return t.Hour()*60 + t.Minute(), nil
+	}
+
+	if strings.TrimSpace(s) == "24:00" {
+		return 24 * 60, nil
+	}
+
+	return 0, fmt.Errorf("invalid time %q", s)
+}
+
+// IsOpen returns if the gym is open at the given time.
+func (s *Schedule) IsOpen(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+
+	for _, i := range s.openings(t, t) {
+		if !t.Before(i.Start) && t.Before(i.End) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Closed returns the periods between start and end when the gym is
+// closed, sorted chronologically and clipped to the given range.
+func (s *Schedule) Closed(start, end time.Time) []Interval {
+	if s == nil || !start.Before(end) {
+		return nil
+	}
+
+	result := []Interval{}
+	from := start
+
+	for _, o := range s.openings(start, end) {
+		if o.Start.After(from) {
+			result = append(result, Interval{Start: from, End: o.Start})
+		}
+
+		if o.End.After(from) {
+			from = o.End
+		}
+	}
+
+	if from.Before(end) {
+		result = append(result, Interval{Start: from, End: end})
+	}
+
+	return result
+}
+
+// Openings returns the opening intervals of the gym that overlap with
+// the given range, sorted chronologically.
+func (s *Schedule) openings(start, end time.Time) []Interval {
+	start = start.In(s.location)
+	end = end.In(s.location)
+
+	result := []Interval{}
+
+	// start the day before, its opening hours can reach past midnight
+	y, m, d := start.Date()
+	day := time.Date(y, m, d-1, 0, 0, 0, 0, s.location)
+
+	for !day.After(end) {
+		y, m, d := day.Date()
+
+		if h := s.hours[day.Weekday()]; h != nil {
+			// time.Date normalizes minutes over 60, this way daylight
+			// saving time changes are taken into account.
+			i := Interval{
+				Start: time.Date(y, m, d, 0, h.open, 0, 0, s.location),
+				End:   time.Date(y, m, d, 0, h.close, 0, 0, s.location),
+			}
+
+			if i.End.After(start) && !i.Start.After(end) {
+				result = append(result, i)
+			}
+		}
+
+		day = time.Date(y, m, d+1, 0, 0, 0, 0, s.location)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+
+	return result
+}
@@ -0,0 +1,215 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/schedule"
+)
+
+func amsterdam(t *testing.T) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location
+}
+
+func TestParse_Errors(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]struct {
+		timezone string
+		hours    map[string]string
+	}{
+		"unknown timezone": {
+			timezone: "Middle/Earth",
+			hours:    map[string]string{"mon": "09:00-17:00"},
+		},
+		"unknown weekday": {
+			timezone: "UTC",
+			hours:    map[string]string{"monday": "09:00-17:00"},
+		},
+		"missing closing time": {
+			timezone: "UTC",
+			hours:    map[string]string{"mon": "09:00"},
+		},
+		"invalid time": {
+			timezone: "UTC",
+			hours:    map[string]string{"mon": "09:00-25:00"},
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := schedule.Parse(test.timezone, test.hours)
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+		})
+	}
+}
+
+func TestSchedule_IsOpen(t *testing.T) {
+	t.Parallel()
+
+	loc := amsterdam(t)
+
+	s, err := schedule.Parse("Europe/Amsterdam", map[string]string{
+		"mon": "09:00-23:00",
+		"fri": "18:00-02:00", // closes on saturday
+		"sun": "10:00-24:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2020-06-01 was a monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2020, time.June, day, hour, min, 0, 0, loc)
+	}
+
+	subtests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{name: "monday before opening", t: at(1, 8, 59), want: false},
+		{name: "monday at opening", t: at(1, 9, 0), want: true},
+		{name: "monday before closing", t: at(1, 22, 59), want: true},
+		{name: "monday at closing", t: at(1, 23, 0), want: false},
+		{name: "tuesday, closed all day", t: at(2, 12, 0), want: false},
+		{name: "friday night", t: at(5, 23, 0), want: true},
+		{name: "saturday after midnight", t: at(6, 1, 0), want: true},
+		{name: "saturday after closing", t: at(6, 2, 0), want: false},
+		{name: "sunday before midnight", t: at(7, 23, 59), want: true},
+		{name: "in other timezone", t: at(1, 8, 0).UTC(), want: false},
+	}
+
+	for _, test := range subtests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := s.IsOpen(test.t); got != test.want {
+				t.Errorf("want %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSchedule_NilIsAlwaysOpen(t *testing.T) {
+	t.Parallel()
+
+	s, err := schedule.Parse("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.IsOpen(time.Time{}) {
+		t.Error("nil schedule is closed")
+	}
+
+	if got := s.Closed(time.Time{}, time.Time{}.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("nil schedule has closed periods: %v", got)
+	}
+}
+
+func TestSchedule_Closed(t *testing.T) {
+	t.Parallel()
+
+	loc := amsterdam(t)
+
+	s, err := schedule.Parse("Europe/Amsterdam", map[string]string{
+		"mon": "09:00-23:00",
+		"tue": "09:00-23:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(day, hour int) time.Time {
+		return time.Date(2020, time.June, day, hour, 0, 0, 0, loc)
+	}
+
+	got := s.Closed(at(1, 12), at(3, 12))
+
+	want := []schedule.Interval{
+		{Start: at(1, 23), End: at(2, 9)},
+		{Start: at(2, 23), End: at(3, 12)},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestGate(t *testing.T) {
+	t.Parallel()
+
+	s, err := schedule.Parse("UTC", map[string]string{
+		"mon": "09:00-17:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2020-06-01 was a monday
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, time.June, 1, hour, min, 0, 0, time.UTC)
+	}
+
+	t.Run("skips while closed", func(t *testing.T) {
+		t.Parallel()
+
+		gate := schedule.NewGate(s, 0)
+
+		for _, test := range []struct {
+			t    time.Time
+			want bool
+		}{
+			{t: at(8, 0), want: false},
+			{t: at(9, 0), want: true},
+			{t: at(16, 59), want: true},
+			{t: at(17, 0), want: false},
+		} {
+			if got := gate.Allow(test.t); got != test.want {
+				t.Errorf("at %v: want %t, got %t", test.t, test.want, got)
+			}
+		}
+	})
+
+	t.Run("slows down while closed", func(t *testing.T) {
+		t.Parallel()
+
+		gate := schedule.NewGate(s, time.Hour)
+
+		for _, test := range []struct {
+			t    time.Time
+			want bool
+		}{
+			{t: at(7, 0), want: true},
+			{t: at(7, 30), want: false},
+			{t: at(8, 0), want: true},
+			{t: at(9, 0), want: true},
+			{t: at(9, 10), want: true},
+			{t: at(17, 0), want: true},
+			{t: at(17, 10), want: false},
+			{t: at(18, 0), want: true},
+		} {
+			if got := gate.Allow(test.t); got != test.want {
+				t.Errorf("at %v: want %t, got %t", test.t, test.want, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+// Attempt describes a single scraping attempt, successful or not.
+type Attempt struct {
+	Gym       string
+	Timestamp time.Time // when the attempt started
+	Number    int       // 1 for the first attempt of a scrape, 2 for its first retry...
+	Latency   time.Duration
+	Status    int        // the HTTP status, 0 if there was no response
+	Error     ErrorClass // empty for successful attempts
+}
+
+// ErrorClass is a broad classification of the errors of the scraping
+// attempts.
+type ErrorClass string
+
+// The error classes of failed attempts.
+const (
+	ErrorCanceled ErrorClass = "canceled" // the context was canceled
+	ErrorTimeout  ErrorClass = "timeout"
+	ErrorNetwork  ErrorClass = "network"
+	ErrorStatus   ErrorClass = "status"   // unsuccessful HTTP status
+	ErrorInvalid  ErrorClass = "invalid"  // undecodable or zero capacity response
+	ErrorRejected ErrorClass = "rejected" // rejected by the validator
+)
+
+// Classify returns the error class of an error returned by a scraping
+// attempt.
+func classify(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	var (
+		statusErr   *StatusError
+		rejectedErr *RejectedError
+		netErr      net.Error
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &statusErr):
+		return ErrorStatus
+	case errors.As(err, &rejectedErr):
+		return ErrorRejected
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorTimeout
+		}
+
+		return ErrorNetwork
+	default:
+		return ErrorInvalid
+	}
+}
+
+// AttemptTracker knows what to do with the scraping attempts of a
+// source, like storing them to monitor the health of the gym
+// endpoints. Trackers are called synchronously from the sources, so
+// they should return quickly.
+type AttemptTracker interface {
+	Track(*Attempt)
+}
+
+// Health summarizes the scraping attempts of a gym.
+type Health struct {
+	Gym      string `json:"gym"`
+	Attempts int    `json:"attempts"`
+	Failures int    `json:"failures"`
+	// the fraction of successful attempts, between 0 and 1
+	SuccessRate float64 `json:"successRate"`
+	// latency percentiles, in seconds
+	LatencyP50 float64 `json:"latencyP50"`
+	LatencyP95 float64 `json:"latencyP95"`
+	LatencyMax float64 `json:"latencyMax"`
+	// number of attempts by HTTP status, 0 for attempts without a
+	// response
+	Statuses map[int]int `json:"statuses"`
+	// number of failed attempts by error class
+	Errors map[ErrorClass]int `json:"errors"`
+	// zero if there are no successful attempts
+	LastSuccess time.Time `json:"lastSuccess"`
+}
+
+// Summarize returns the health of each gym with attempts, sorted by gym
+// name.
+func Summarize(attempts []*Attempt) []*Health {
+	byGym := map[string][]*Attempt{}
+	for _, a := range attempts {
+		byGym[a.Gym] = append(byGym[a.Gym], a)
+	}
+
+	result := make([]*Health, 0, len(byGym))
+	for name, aa := range byGym {
+		result = append(result, summarize(name, aa))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Gym < result[j].Gym
+	})
+
+	return result
+}
+
+// Summarize returns the health of a gym with the given attempts.
+func summarize(name string, attempts []*Attempt) *Health {
+	result := &Health{
+		Gym:      name,
+		Attempts: len(attempts),
+		Statuses: map[int]int{},
+		Errors:   map[ErrorClass]int{},
+	}
+
+	latencies := make([]time.Duration, len(attempts))
+
+	for i, a := range attempts {
+		latencies[i] = a.Latency
+		result.Statuses[a.Status]++
+
+		if a.Error != "" {
+			result.Failures++
+			result.Errors[a.Error]++
+
+			continue
+		}
+
+		if a.Timestamp.After(result.LastSuccess) {
+			result.LastSuccess = a.Timestamp
+		}
+	}
+
+	result.SuccessRate = float64(result.Attempts-result.Failures) /
+		float64(result.Attempts)
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+
+	result.LatencyP50 = percentile(latencies, 0.50).Seconds()
+	result.LatencyP95 = percentile(latencies, 0.95).Seconds()
+	result.LatencyMax = latencies[len(latencies)-1].Seconds()
+
+	return result
+}
+
+// Percentile returns the p percentile of the sorted durations, using
+// the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
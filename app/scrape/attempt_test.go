@@ -0,0 +1,155 @@
+package scrape_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+// attemptList is a scrape.AttemptTracker that remembers all the
+// attempts.
+type attemptList struct {
+	mutex    sync.Mutex
+	attempts []scrape.Attempt
+}
+
+func (l *attemptList) Track(a *scrape.Attempt) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.attempts = append(l.attempts, *a)
+}
+
+// timeoutError is a net.Error that reports a timeout, like the errors
+// of http.Client timeouts, which also match context.DeadlineExceeded.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (timeoutError) Is(err error) bool {
+	return err == context.DeadlineExceeded
+}
+
+func TestScrape_TracksAttempts(t *testing.T) {
+	t.Parallel()
+
+	httper := &sequenceHTTPer{
+		responses: []fakeResponse{
+			unavailableResponse,
+			{err: &timeoutError{}},
+			{status: http.StatusOK, body: `{"People": 1, "Capacity": 0}`},
+		},
+	}
+
+	tracker := &attemptList{}
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		httper,
+		fixedClock,
+		scrape.Config{
+			GymName: "test",
+			Retry:   fastRetries(10),
+			Tracker: tracker,
+		},
+	)
+
+	if _, err := scraper.Scrape(context.Background()); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	want := []scrape.Attempt{
+		{
+			Gym:       "test",
+			Timestamp: fixedClock(),
+			Number:    1,
+			Status:    http.StatusServiceUnavailable,
+			Error:     scrape.ErrorStatus,
+		},
+		{
+			Gym:       "test",
+			Timestamp: fixedClock(),
+			Number:    2,
+			Error:     scrape.ErrorTimeout,
+		},
+		{
+			Gym:       "test",
+			Timestamp: fixedClock(),
+			Number:    3,
+			Status:    http.StatusOK,
+			Error:     scrape.ErrorInvalid,
+		},
+	}
+
+	if !reflect.DeepEqual(want, tracker.attempts) {
+		t.Errorf("\nwant %+v\n got %+v", want, tracker.attempts)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+	attempt := func(gym string, minutes int, latency time.Duration,
+		status int, class scrape.ErrorClass) *scrape.Attempt {
+		return &scrape.Attempt{
+			Gym:       gym,
+			Timestamp: t0.Add(time.Duration(minutes) * time.Minute),
+			Number:    1,
+			Latency:   latency,
+			Status:    status,
+			Error:     class,
+		}
+	}
+
+	attempts := []*scrape.Attempt{
+		attempt("b", 0, time.Second, 200, ""),
+		attempt("a", 0, 100*time.Millisecond, 200, ""),
+		attempt("a", 1, 300*time.Millisecond, 503, scrape.ErrorStatus),
+		attempt("a", 2, 200*time.Millisecond, 200, ""),
+		attempt("a", 3, 10*time.Second, 0, scrape.ErrorTimeout),
+	}
+
+	want := []*scrape.Health{
+		{
+			Gym:         "a",
+			Attempts:    4,
+			Failures:    2,
+			SuccessRate: 0.5,
+			LatencyP50:  0.2,
+			LatencyP95:  10,
+			LatencyMax:  10,
+			Statuses:    map[int]int{0: 1, 200: 2, 503: 1},
+			Errors: map[scrape.ErrorClass]int{
+				scrape.ErrorStatus:  1,
+				scrape.ErrorTimeout: 1,
+			},
+			LastSuccess: t0.Add(2 * time.Minute),
+		},
+		{
+			Gym:         "b",
+			Attempts:    1,
+			SuccessRate: 1,
+			LatencyP50:  1,
+			LatencyP95:  1,
+			LatencyMax:  1,
+			Statuses:    map[int]int{200: 1},
+			Errors:      map[scrape.ErrorClass]int{},
+			LastSuccess: t0,
+		},
+	}
+
+	got := scrape.Summarize(attempts)
+
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("\nwant %+v\n got %+v", want, got)
+	}
+}
@@ -0,0 +1,197 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed breakers let all scrapes through.
+	Closed State = iota
+	// Open breakers reject all scrapes.
+	Open
+	// HalfOpen breakers let a single scrape through to probe if the
+	// source has recovered.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("unknown state (%d)", int(s))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ErrOpenCircuit is returned by Breaker.Scrape when the breaker rejects
+// a scrape because its source has been failing.
+var ErrOpenCircuit = errors.New("circuit breaker is open")
+
+// BreakerConfig controls when a breaker opens and closes.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that will
+	// open the circuit, 0 disables the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before letting a
+	// probe scrape through.
+	OpenTimeout time.Duration
+}
+
+// Breaker is a circuit breaker that protects a failing source from
+// being scraped over and over.
+//
+// It starts closed, letting all scrapes through to its source. After a
+// number of consecutive failures it opens, rejecting all scrapes with
+// ErrOpenCircuit. Once the open timeout has passed, the next scrape is
+// let through as a probe (half-open state): if it succeeds the breaker
+// closes again, otherwise it opens for another timeout.
+//
+// Values rejected by the validator of the source are not failures, as
+// the source did respond. The breaker only logs its state changes, not
+// the individual failures.
+type Breaker struct {
+	logger *log.Logger
+	clock  Clock
+	name   string
+	config BreakerConfig
+	source Source
+
+	mux      sync.Mutex
+	state    State
+	failures int       // consecutive failures
+	openedAt time.Time // when the breaker was last opened
+}
+
+// NewBreaker returns a closed breaker that protects the given source.
+// The name is used to identify the breaker in the logs.
+func NewBreaker(
+	logger *log.Logger,
+	clock Clock,
+	name string,
+	config BreakerConfig,
+	source Source,
+) *Breaker {
+	return &Breaker{
+		logger: logger,
+		clock:  clock,
+		name:   name,
+		config: config,
+		source: source,
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return b.state
+}
+
+// Scrape scrapes the source of the breaker if the circuit allows it or
+// returns ErrOpenCircuit otherwise.
+func (b *Breaker) Scrape(ctx context.Context) (*gym.Utilization, error) {
+	if b.config.FailureThreshold <= 0 {
+		return b.source.Scrape(ctx)
+	}
+
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	u, err := b.source.Scrape(ctx)
+
+	b.record(err)
+
+	return u, err
+}
+
+// Allow checks if a scrape can go through, moving an open breaker to
+// half-open if its timeout has passed.
+func (b *Breaker) allow() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	switch b.state {
+	case Closed:
+		return nil
+	case Open:
+		if b.clock().Sub(b.openedAt) < b.config.OpenTimeout {
+			return ErrOpenCircuit
+		}
+
+		b.setState(HalfOpen, "probing the source")
+
+		return nil
+	default: // a probe is already in flight
+		return ErrOpenCircuit
+	}
+}
+
+// Record updates the state of the breaker with the result of a scrape.
+func (b *Breaker) record(err error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	// cancelations say nothing about the health of the source
+	if errors.Is(err, context.Canceled) {
+		if b.state == HalfOpen {
+			b.setState(Open, "probe canceled")
+		}
+
+		return
+	}
+
+	// rejected values were scraped, so the source is healthy, even if
+	// they are not valid
+	var rejectedErr *RejectedError
+	if err == nil || errors.As(err, &rejectedErr) {
+		b.failures = 0
+
+		if b.state != Closed {
+			b.setState(Closed, "the source has recovered")
+		}
+
+		return
+	}
+
+	b.failures++
+
+	switch {
+	case b.state == HalfOpen:
+		b.openedAt = b.clock()
+		b.setState(Open, fmt.Sprintf("probe failed: %v", err))
+	case b.failures >= b.config.FailureThreshold:
+		b.openedAt = b.clock()
+		b.setState(Open, fmt.Sprintf("%d consecutive failures, last one: %v",
+			b.failures, err))
+	}
+}
+
+// SetState changes the state and logs the change. It assumes the mutex
+// is locked.
+func (b *Breaker) setState(s State, reason string) {
+	b.logger.Printf("circuit breaker %s: %s -> %s: %s",
+		b.name, b.state, s, reason)
+
+	b.state = s
+}
@@ -0,0 +1,313 @@
+package scrape_test
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+// fakeSource is a scrape.Source that fails or succeeds on demand and
+// counts how many times it has been scraped.
+type fakeSource struct {
+	mutex sync.Mutex
+	err   error
+	calls int
+}
+
+func (f *fakeSource) Scrape(context.Context) (*gym.Utilization, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls++
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &gym.Utilization{People: 1, Capacity: 2}, nil
+}
+
+func (f *fakeSource) fail(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.err = err
+}
+
+func (f *fakeSource) Calls() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.calls
+}
+
+// fakeClock is a scrape.Clock that only moves when told to.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+
+	const (
+		threshold = 3
+		timeout   = time.Minute
+	)
+
+	source := &fakeSource{}
+	clock := &fakeClock{}
+
+	breaker := scrape.NewBreaker(
+		logger(t),
+		clock.Now,
+		"test",
+		scrape.BreakerConfig{
+			FailureThreshold: threshold,
+			OpenTimeout:      timeout,
+		},
+		source,
+	)
+
+	ctx := context.Background()
+
+	checkState := func(t *testing.T, want scrape.State) {
+		t.Helper()
+
+		if got := breaker.State(); got != want {
+			t.Fatalf("state: want %s, got %s", want, got)
+		}
+	}
+
+	checkState(t, scrape.Closed)
+
+	if _, err := breaker.Scrape(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// fail enough times to open the breaker
+	cause := errors.New("some error")
+	source.fail(cause)
+
+	for i := 0; i < threshold; i++ {
+		checkState(t, scrape.Closed)
+
+		if _, err := breaker.Scrape(ctx); !errors.Is(err, cause) {
+			t.Fatalf("want source error, got %v", err)
+		}
+	}
+
+	checkState(t, scrape.Open)
+
+	// an open breaker doesn't reach the source
+	calls := source.Calls()
+
+	if _, err := breaker.Scrape(ctx); !errors.Is(err, scrape.ErrOpenCircuit) {
+		t.Fatalf("want open circuit error, got %v", err)
+	}
+
+	if got := source.Calls(); got != calls {
+		t.Fatalf("open breaker scraped the source")
+	}
+
+	// after the timeout, a failing probe opens the breaker again
+	clock.Advance(timeout)
+
+	if _, err := breaker.Scrape(ctx); !errors.Is(err, cause) {
+		t.Fatalf("want source error, got %v", err)
+	}
+
+	checkState(t, scrape.Open)
+
+	if _, err := breaker.Scrape(ctx); !errors.Is(err, scrape.ErrOpenCircuit) {
+		t.Fatalf("want open circuit error, got %v", err)
+	}
+
+	// after another timeout, a successful probe closes the breaker
+	clock.Advance(timeout)
+	source.fail(nil)
+
+	if _, err := breaker.Scrape(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	checkState(t, scrape.Closed)
+
+	// and the failure count starts from scratch
+	source.fail(cause)
+
+	for i := 0; i < threshold-1; i++ {
+		if _, err := breaker.Scrape(ctx); !errors.Is(err, cause) {
+			t.Fatalf("want source error, got %v", err)
+		}
+	}
+
+	checkState(t, scrape.Closed)
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	t.Parallel()
+
+	source := &fakeSource{err: errors.New("some error")}
+
+	breaker := scrape.NewBreaker(
+		logger(t),
+		nil, // irrelevant clock
+		"test",
+		scrape.BreakerConfig{}, // zero threshold disables the breaker
+		source,
+	)
+
+	for i := 0; i < 10; i++ {
+		_, err := breaker.Scrape(context.Background())
+		if errors.Is(err, scrape.ErrOpenCircuit) {
+			t.Fatalf("disabled breaker opened after %d calls", i)
+		}
+	}
+
+	if got, want := source.Calls(), 10; got != want {
+		t.Errorf("calls: want %d, got %d", want, got)
+	}
+}
+
+func TestBreaker_RejectedValues(t *testing.T) {
+	t.Parallel()
+
+	const (
+		threshold = 2
+		timeout   = time.Minute
+	)
+
+	source := &fakeSource{}
+	clock := &fakeClock{}
+
+	breaker := scrape.NewBreaker(
+		logger(t),
+		clock.Now,
+		"test",
+		scrape.BreakerConfig{
+			FailureThreshold: threshold,
+			OpenTimeout:      timeout,
+		},
+		source,
+	)
+
+	ctx := context.Background()
+	cause := errors.New("some error")
+	rejected := &scrape.RejectedError{Reasons: []string{"too many people"}}
+
+	// rejected values between failures reset the count
+	for i := 0; i < 3; i++ {
+		for _, err := range []error{cause, rejected} {
+			source.fail(err)
+
+			if _, got := breaker.Scrape(ctx); !errors.Is(got, err) {
+				t.Fatalf("want %v, got %v", err, got)
+			}
+		}
+	}
+
+	if got := breaker.State(); got != scrape.Closed {
+		t.Fatalf("state: want %s, got %s", scrape.Closed, got)
+	}
+
+	// a probe that returns a rejected value closes the breaker
+	source.fail(cause)
+
+	for i := 0; i < threshold; i++ {
+		if _, err := breaker.Scrape(ctx); !errors.Is(err, cause) {
+			t.Fatalf("want source error, got %v", err)
+		}
+	}
+
+	if got := breaker.State(); got != scrape.Open {
+		t.Fatalf("state: want %s, got %s", scrape.Open, got)
+	}
+
+	clock.Advance(timeout)
+	source.fail(rejected)
+
+	if _, err := breaker.Scrape(ctx); !errors.Is(err, rejected) {
+		t.Fatalf("want rejected value error, got %v", err)
+	}
+
+	if got := breaker.State(); got != scrape.Closed {
+		t.Fatalf("state: want %s, got %s", scrape.Closed, got)
+	}
+}
+
+func TestBreaker_CanceledProbe(t *testing.T) {
+	t.Parallel()
+
+	const timeout = time.Minute
+
+	var logs strings.Builder
+
+	source := &fakeSource{err: errors.New("some error")}
+	clock := &fakeClock{}
+
+	breaker := scrape.NewBreaker(
+		log.New(&logs, "", 0),
+		clock.Now,
+		"test",
+		scrape.BreakerConfig{
+			FailureThreshold: 1,
+			OpenTimeout:      timeout,
+		},
+		source,
+	)
+
+	ctx := context.Background()
+
+	if _, err := breaker.Scrape(ctx); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	clock.Advance(timeout)
+	source.fail(context.Canceled)
+
+	if _, err := breaker.Scrape(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+
+	if got := breaker.State(); got != scrape.Open {
+		t.Fatalf("state: want %s, got %s", scrape.Open, got)
+	}
+
+	want := "circuit breaker test: half-open -> open: probe canceled\n"
+	if !strings.HasSuffix(logs.String(), want) {
+		t.Errorf("transition not logged, logs:\n%s", logs.String())
+	}
+
+	// the canceled probe doesn't restart the timeout
+	source.fail(nil)
+
+	if _, err := breaker.Scrape(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := breaker.State(); got != scrape.Closed {
+		t.Fatalf("state: want %s, got %s", scrape.Closed, got)
+	}
+}
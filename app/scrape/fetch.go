@@ -0,0 +1,215 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Fetcher scrapes gym endpoints over HTTP, retrying failed attempts. It
+// is shared by all the HTTP sources, which only need to say how to
+// build their requests and how to decode their responses.
+type fetcher struct {
+	logger    *log.Logger
+	client    HTTPer
+	clock     Clock
+	retry     RetryConfig
+	jitter    func() float64
+	gym       string
+	recorder  Recorder       // nil to disable recording
+	validator *Validator     // nil to disable validation
+	tracker   AttemptTracker // nil to disable tracking
+}
+
+// RequestFunc builds the request to scrape an endpoint.
+type requestFunc func(context.Context) (*http.Request, error)
+
+// DecodeFunc extracts a reading from the body of a successful
+// response.
+type decodeFunc func(body []byte) (Reading, error)
+
+// Decoder knows how to extract a reading from the body of a successful
+// response. All the HTTP sources are decoders.
+type Decoder interface {
+	Decode(body []byte) (Reading, error)
+}
+
+// Reading is what a decoder extracts from a response.
+type Reading struct {
+	People   uint64
+	Capacity uint64
+	// UnknownFields are the fields of the response the decoder doesn't
+	// know about, for decoders that can detect them.
+	UnknownFields []string
+}
+
+func newFetcher(
+	logger *log.Logger,
+	client HTTPer,
+	clock Clock,
+	config Config,
+) fetcher {
+	return fetcher{
+		logger:    logger,
+		client:    client,
+		clock:     clock,
+		retry:     config.Retry,
+		jitter:    rand.New(rand.NewSource(time.Now().UnixNano())).Float64,
+		gym:       config.GymName,
+		recorder:  config.Recorder,
+		validator: config.Validator,
+		tracker:   config.Tracker,
+	}
+}
+
+// Scrape does as many attempts to scrape the endpoint as allowed by the
+// retry configuration, see RetryConfig.
+func (f *fetcher) scrape(
+	ctx context.Context,
+	request requestFunc,
+	decode decodeFunc,
+) (*gym.Utilization, error) {
+	for attempt := 1; ; attempt++ {
+		u, err := f.trackedScrapeOnce(ctx, attempt, request, decode)
+		if err == nil {
+			return u, nil
+		}
+
+		// no retries if the caller gave up
+		if attempt >= f.retry.MaxAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait, ok := f.retry.backoff(attempt, err, f.jitter)
+		if !ok {
+			return nil, err
+		}
+
+		f.logger.Printf("scraping %s: attempt %d/%d failed: %v; "+
+			"retrying in %v", f.gym, attempt, f.retry.MaxAttempts,
+			err, wait)
+
+		if werr := sleep(ctx, wait); werr != nil {
+			return nil, fmt.Errorf("%v; aborting retries: %w", err, werr)
+		}
+	}
+}
+
+// TrackedScrapeOnce does a single attempt to scrape the endpoint and
+// reports it to the tracker of the fetcher, if any.
+func (f *fetcher) trackedScrapeOnce(
+	ctx context.Context,
+	number int,
+	request requestFunc,
+	decode decodeFunc,
+) (*gym.Utilization, error) {
+	if f.tracker == nil {
+		u, _, err := f.scrapeOnce(ctx, request, decode)
+		return u, err
+	}
+
+	start := f.clock()
+
+	u, status, err := f.scrapeOnce(ctx, request, decode)
+
+	f.tracker.Track(&Attempt{
+		Gym:       f.gym,
+		Timestamp: start,
+		Number:    number,
+		Latency:   f.clock().Sub(start),
+		Status:    status,
+		Error:     classify(err),
+	})
+
+	return u, err
+}
+
+// ScrapeOnce does a single attempt to scrape the endpoint. It also
+// returns the HTTP status of the response, or 0 if there was none.
+func (f *fetcher) scrapeOnce(
+	ctx context.Context,
+	request requestFunc,
+	decode decodeFunc,
+) (*gym.Utilization, int, error) {
+	req, err := request(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating request: %v", err)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed %s %s: %w",
+			req.Method, req.URL, err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, resp.StatusCode, fmt.Errorf(
+				"status %d (%s); error reading response body: %w",
+				resp.StatusCode, http.StatusText(resp.StatusCode), err)
+		}
+
+		return nil, resp.StatusCode,
+			fmt.Errorf("reading response body: %w", err)
+	}
+
+	rec := &Recording{
+		Gym:       f.gym,
+		Timestamp: f.clock(),
+		Status:    resp.StatusCode,
+		Header:    resp.Header,
+		Body:      string(body),
+	}
+
+	if f.recorder != nil {
+		if err := f.recorder.Record(rec); err != nil {
+			f.logger.Printf("scraping %s: recording response: %v",
+				f.gym, err)
+		}
+	}
+
+	u, unknownFields, err := rec.utilization(decode)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	u, err = f.validate(u, unknownFields)
+
+	return u, resp.StatusCode, err
+}
+
+// Validate checks the utilization with the validator of the fetcher, if
+// any. Rejected values are returned as a RejectedError and suspicious
+// values are flagged.
+func (f *fetcher) validate(
+	u *gym.Utilization,
+	unknownFields []string,
+) (*gym.Utilization, error) {
+	if f.validator == nil {
+		return u, nil
+	}
+
+	verdict, reasons := f.validator.Validate(u, unknownFields)
+
+	switch verdict {
+	case Rejected:
+		return nil, &RejectedError{Value: u, Reasons: reasons}
+	case Suspicious:
+		f.logger.Printf("scraping %s: suspicious value %v: %s",
+			f.gym, u, strings.Join(reasons, "; "))
+
+		u.Suspicious = true
+	}
+
+	return u, nil
+}
@@ -0,0 +1,178 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// HTMLConfig is the configuration of an HTMLSource.
+type HTMLConfig struct {
+	// PeoplePattern and CapacityPattern are regular expressions with
+	// a capturing group for the number of people and the capacity,
+	// like `(\d+) / \d+ climbers` and `\d+ / (\d+) climbers`.
+	PeoplePattern   string
+	CapacityPattern string
+	// Raw makes the patterns match the raw HTML of the page instead of
+	// its text, useful when the numbers are in tag attributes.
+	Raw bool
+}
+
+// HTMLSource is a source for gyms that only publish their utilization
+// in a web page, like "12 / 80 climbers".
+//
+// The people and capacity are extracted using regular expressions. By
+// default they are matched against the text of the page: its HTML
+// without scripts, styles and tags, with entities unescaped and all
+// runs of white space collapsed into a single space. This way the
+// patterns don't depend on how the numbers are marked up.
+//
+// The URL is a template like in JSONSource.
+type HTMLSource struct {
+	fetcher  fetcher
+	url      *template.Template
+	people   *regexp.Regexp
+	capacity *regexp.Regexp
+	raw      bool
+	data     templateData
+}
+
+// NewHTMLSource returns an HTMLSource or an error if its configuration
+// is invalid.
+func NewHTMLSource(
+	logger *log.Logger,
+	client HTTPer,
+	clock Clock,
+	config Config,
+	htmlConfig HTMLConfig,
+) (*HTMLSource, error) {
+	url, err := template.New("url").Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing URL template: %v", err)
+	}
+
+	people, err := compileNumberPattern(htmlConfig.PeoplePattern)
+	if err != nil {
+		return nil, fmt.Errorf("people pattern: %v", err)
+	}
+
+	capacity, err := compileNumberPattern(htmlConfig.CapacityPattern)
+	if err != nil {
+		return nil, fmt.Errorf("capacity pattern: %v", err)
+	}
+
+	return &HTMLSource{
+		fetcher:  newFetcher(logger, client, clock, config),
+		url:      url,
+		people:   people,
+		capacity: capacity,
+		raw:      htmlConfig.Raw,
+		data: templateData{
+			GymName: config.GymName,
+			GymID:   config.GymID,
+		},
+	}, nil
+}
+
+func compileNumberPattern(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if re.NumSubexp() < 1 {
+		return nil, fmt.Errorf("pattern %q has no capturing group", s)
+	}
+
+	return re, nil
+}
+
+// Scrape fetches the gym page and extracts its current utilization,
+// retrying failed attempts like Scraper.Scrape.
+func (s *HTMLSource) Scrape(ctx context.Context) (*gym.Utilization, error) {
+	return s.fetcher.scrape(ctx, s.request, s.Decode)
+}
+
+func (s *HTMLSource) request(ctx context.Context) (*http.Request, error) {
+	var url strings.Builder
+	if err := s.url.Execute(&url, s.data); err != nil {
+		return nil, fmt.Errorf("executing URL template: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "text/html")
+
+	return req, nil
+}
+
+// Decode implements Decoder.
+func (s *HTMLSource) Decode(body []byte) (Reading, error) {
+	page := string(body)
+	if !s.raw {
+		page = htmlText(page)
+	}
+
+	people, err := findNumber(s.people, page)
+	if err != nil {
+		return Reading{}, fmt.Errorf("extracting people: %v", err)
+	}
+
+	capacity, err := findNumber(s.capacity, page)
+	if err != nil {
+		return Reading{}, fmt.Errorf("extracting capacity: %v", err)
+	}
+
+	return Reading{People: people, Capacity: capacity}, nil
+}
+
+var (
+	invisibleRE  = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+	commentRE    = regexp.MustCompile(`(?s)<!--.*?-->`)
+	tagRE        = regexp.MustCompile(`(?s)<[^>]*>`)
+	whitespaceRE = regexp.MustCompile(`[\s\p{Zs}]+`)
+)
+
+// HTMLText returns the text of an HTML document, see HTMLSource.
+func htmlText(s string) string {
+	s = invisibleRE.ReplaceAllString(s, " ")
+	s = commentRE.ReplaceAllString(s, " ")
+	s = tagRE.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	s = whitespaceRE.ReplaceAllString(s, " ")
+
+	return strings.TrimSpace(s)
+}
+
+// FindNumber returns the number captured by the first group of the
+// first match of the pattern.
+func findNumber(re *regexp.Regexp, s string) (uint64, error) {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("no match for %q", re)
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(m[1]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("match for %q is not a natural number: %q",
+			re, m[1])
+	}
+
+	return n, nil
+}
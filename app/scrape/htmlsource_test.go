@@ -0,0 +1,148 @@
+package scrape_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+func TestNewHTMLSource_InvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]scrape.HTMLConfig{
+		"empty people pattern": {
+			CapacityPattern: `(\d+)`,
+		},
+		"invalid capacity pattern": {
+			PeoplePattern:   `(\d+)`,
+			CapacityPattern: `(\d+`,
+		},
+		"pattern without group": {
+			PeoplePattern:   `(\d+)`,
+			CapacityPattern: `\d+`,
+		},
+	}
+
+	for name, config := range subtests {
+		config := config
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := scrape.NewHTMLSource(
+				logger(t),
+				nil, // irrelevant httper
+				nil, // irrelevant clock
+				scrape.Config{},
+				config,
+			)
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+		})
+	}
+}
+
+func TestHTMLSource(t *testing.T) {
+	t.Parallel()
+
+	climbers := scrape.HTMLConfig{
+		PeoplePattern:   `(\d+) / \d+ climbers`,
+		CapacityPattern: `\d+ / (\d+) climbers`,
+	}
+
+	subtests := []struct {
+		fixture string
+		config  scrape.HTMLConfig
+		want    *gym.Utilization // nil for errors
+	}{
+		{
+			fixture: "climbers.html",
+			config:  climbers,
+			want:    &gym.Utilization{People: 37, Capacity: 120},
+		}, {
+			fixture: "full.html",
+			config:  climbers,
+			want:    &gym.Utilization{People: 80, Capacity: 80},
+		}, {
+			fixture: "attributes.html",
+			config: scrape.HTMLConfig{
+				PeoplePattern:   `data-people="(\d+)"`,
+				CapacityPattern: `data-capacity="(\d+)"`,
+				Raw:             true,
+			},
+			want: &gym.Utilization{People: 12, Capacity: 80},
+		}, {
+			fixture: "closed.html",
+			config:  climbers,
+		}, {
+			fixture: "zero-capacity.html",
+			config:  climbers,
+		},
+	}
+
+	for _, test := range subtests {
+		test := test
+
+		t.Run(test.fixture, func(t *testing.T) {
+			t.Parallel()
+
+			page, err := ioutil.ReadFile(
+				filepath.Join("testdata", "html", test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			httper := mockHTTPer{
+				do: func(r *http.Request) (*http.Response, error) {
+					if r.Method != http.MethodGet {
+						t.Errorf("method: want GET, got %s", r.Method)
+					}
+
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body: ioutil.NopCloser(
+							bytes.NewBuffer(page),
+						),
+					}, nil
+				},
+			}
+
+			source, err := scrape.NewHTMLSource(
+				logger(t),
+				httper,
+				func() time.Time { return time.Time{} },
+				scrape.Config{URL: "http://example.com"},
+				test.config,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := source.Scrape(context.Background())
+
+			if test.want == nil {
+				if err == nil {
+					t.Fatalf("unexpected success: %v", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Equal(test.want) {
+				t.Errorf("\nwant %v\n got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// JSONConfig is the configuration of a JSONSource.
+type JSONConfig struct {
+	// Method is the HTTP method of the requests, GET by default.
+	Method string
+	// Body is the body of the requests, empty for no body. It is
+	// sent with a JSON content type.
+	Body string
+	// PeopleField and CapacityField select the people and capacity
+	// from the response, see JSONSource.
+	PeopleField   string
+	CapacityField string
+}
+
+// JSONSource is a configurable source for JSON APIs.
+//
+// The URL and body of the requests are Go templates, where you can use
+// {{.GymName}} and {{.GymID}} to refer to the values in the config.
+//
+// The people and capacity are taken from the response using JSONPath
+// like selectors, for instance "data.gyms[0].occupancy". A leading "$."
+// is allowed but optional. The selected values can be JSON numbers or
+// strings with numbers.
+type JSONSource struct {
+	fetcher  fetcher
+	method   string
+	url      *template.Template
+	body     *template.Template
+	people   path
+	capacity path
+	data     templateData
+}
+
+// TemplateData is the data available to the URL and body templates of
+// a JSONSource.
+type templateData struct {
+	GymName string
+	GymID   int
+}
+
+// NewJSONSource returns a JSONSource or an error if its configuration
+// is invalid.
+func NewJSONSource(
+	logger *log.Logger,
+	client HTTPer,
+	clock Clock,
+	config Config,
+	jsonConfig JSONConfig,
+) (*JSONSource, error) {
+	method := jsonConfig.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	url, err := template.New("url").Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing URL template: %v", err)
+	}
+
+	var body *template.Template
+	if jsonConfig.Body != "" {
+		body, err = template.New("body").Parse(jsonConfig.Body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing body template: %v", err)
+		}
+	}
+
+	people, err := parsePath(jsonConfig.PeopleField)
+	if err != nil {
+		return nil, fmt.Errorf("parsing people field: %v", err)
+	}
+
+	capacity, err := parsePath(jsonConfig.CapacityField)
+	if err != nil {
+		return nil, fmt.Errorf("parsing capacity field: %v", err)
+	}
+
+	return &JSONSource{
+		fetcher:  newFetcher(logger, client, clock, config),
+		method:   method,
+		url:      url,
+		body:     body,
+		people:   people,
+		capacity: capacity,
+		data: templateData{
+			GymName: config.GymName,
+			GymID:   config.GymID,
+		},
+	}, nil
+}
+
+// Scrape asks the gym endpoint for its current utilization, retrying
+// failed attempts like Scraper.Scrape.
+func (s *JSONSource) Scrape(ctx context.Context) (*gym.Utilization, error) {
+	return s.fetcher.scrape(ctx, s.request, s.Decode)
+}
+
+func (s *JSONSource) request(ctx context.Context) (*http.Request, error) {
+	var url strings.Builder
+	if err := s.url.Execute(&url, s.data); err != nil {
+		return nil, fmt.Errorf("executing URL template: %v", err)
+	}
+
+	var body io.Reader
+	if s.body != nil {
+		var b bytes.Buffer
+		if err := s.body.Execute(&b, s.data); err != nil {
+			return nil, fmt.Errorf("executing body template: %v", err)
+		}
+
+		body = &b
+	}
+
+	req, err := http.NewRequestWithContext(ctx, s.method, url.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// Decode implements Decoder.
+func (s *JSONSource) Decode(body []byte) (Reading, error) {
+	var response interface{}
+
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+
+	if err := d.Decode(&response); err != nil {
+		return Reading{}, fmt.Errorf("decoding response: %v", err)
+	}
+
+	people, err := s.people.uint64(response)
+	if err != nil {
+		return Reading{}, fmt.Errorf("selecting people: %v", err)
+	}
+
+	capacity, err := s.capacity.uint64(response)
+	if err != nil {
+		return Reading{}, fmt.Errorf("selecting capacity: %v", err)
+	}
+
+	return Reading{People: people, Capacity: capacity}, nil
+}
+
+// Path is a parsed JSONPath like selector. Each step is either a string
+// (an object key) or an int (an array index).
+type path struct {
+	raw   string
+	steps []interface{}
+}
+
+// ParsePath parses selectors like "a.b[0].c" or "$[2].a".
+func parsePath(s string) (path, error) {
+	result := path{raw: s}
+
+	rest := strings.TrimPrefix(strings.TrimPrefix(s, "$"), ".")
+	if rest == "" {
+		return path{}, fmt.Errorf("empty selector %q", s)
+	}
+
+	for _, segment := range strings.Split(rest, ".") {
+		key := segment
+		indexes := ""
+
+		if i := strings.Index(segment, "["); i != -1 {
+			key = segment[:i]
+			indexes = segment[i:]
+		}
+
+		if key != "" {
+			result.steps = append(result.steps, key)
+		} else if indexes == "" {
+			return path{}, fmt.Errorf("empty key in selector %q", s)
+		}
+
+		for indexes != "" {
+			end := strings.Index(indexes, "]")
+			if indexes[0] != '[' || end == -1 {
+				return path{}, fmt.Errorf("invalid index in selector %q", s)
+			}
+
+			n, err := strconv.Atoi(indexes[1:end])
+			if err != nil || n < 0 {
+				return path{}, fmt.Errorf("invalid index in selector %q", s)
+			}
+
+			result.steps = append(result.steps, n)
+			indexes = indexes[end+1:]
+		}
+	}
+
+	return result, nil
+}
+
+// Select returns the value selected by the path in a decoded JSON
+// document.
+func (p path) selectFrom(v interface{}) (interface{}, error) {
+	for _, step := range p.steps {
+		switch step := step.(type) {
+		case string:
+			object, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: want an object to get %q from, "+
+					"got %T", p.raw, step, v)
+			}
+
+			v, ok = object[step]
+			if !ok {
+				return nil, fmt.Errorf("%s: key %q not found", p.raw, step)
+			}
+		case int:
+			array, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: want an array to get [%d] from, "+
+					"got %T", p.raw, step, v)
+			}
+
+			if step >= len(array) {
+				return nil, fmt.Errorf("%s: index %d out of range (%d)",
+					p.raw, step, len(array))
+			}
+
+			v = array[step]
+		}
+	}
+
+	return v, nil
+}
+
+// Uint64 returns the value selected by the path as an uint64.
+func (p path) uint64(v interface{}) (uint64, error) {
+	selected, err := p.selectFrom(v)
+	if err != nil {
+		return 0, err
+	}
+
+	var s string
+
+	switch selected := selected.(type) {
+	case json.Number:
+		s = selected.String()
+	case string:
+		s = strings.TrimSpace(selected)
+	default:
+		return 0, fmt.Errorf("%s: want a number, got %T", p.raw, selected)
+	}
+
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n, nil
+	}
+
+	// accept integral floats, like 42.0
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f != math.Trunc(f) || f > math.MaxUint64 {
+		return 0, fmt.Errorf("%s: want a natural number, got %q", p.raw, s)
+	}
+
+	return uint64(f), nil
+}
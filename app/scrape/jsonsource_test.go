@@ -0,0 +1,255 @@
+package scrape_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+func TestNewJSONSource_InvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	valid := scrape.JSONConfig{
+		PeopleField:   "people",
+		CapacityField: "capacity",
+	}
+
+	subtests := map[string]struct {
+		url    string
+		config func(c scrape.JSONConfig) scrape.JSONConfig
+	}{
+		"invalid URL template": {
+			url:    "http://{{.Oops",
+			config: func(c scrape.JSONConfig) scrape.JSONConfig { return c },
+		},
+		"invalid body template": {
+			config: func(c scrape.JSONConfig) scrape.JSONConfig {
+				c.Body = "{{"
+				return c
+			},
+		},
+		"empty people field": {
+			config: func(c scrape.JSONConfig) scrape.JSONConfig {
+				c.PeopleField = ""
+				return c
+			},
+		},
+		"invalid capacity field": {
+			config: func(c scrape.JSONConfig) scrape.JSONConfig {
+				c.CapacityField = "a[x]"
+				return c
+			},
+		},
+		"unclosed index": {
+			config: func(c scrape.JSONConfig) scrape.JSONConfig {
+				c.CapacityField = "a[0"
+				return c
+			},
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := scrape.NewJSONSource(
+				logger(t),
+				nil, // irrelevant httper
+				nil, // irrelevant clock
+				scrape.Config{URL: test.url},
+				test.config(valid),
+			)
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+		})
+	}
+}
+
+func TestJSONSource_Request(t *testing.T) {
+	t.Parallel()
+
+	httper := mockHTTPer{
+		do: func(r *http.Request) (*http.Response, error) {
+			if got, want := r.Method, http.MethodPut; got != want {
+				t.Errorf("method: want %s, got %s", want, got)
+			}
+
+			want := "http://example.com/gyms/42?name=sputnik"
+			if got := r.URL.String(); got != want {
+				t.Errorf("URL:\nwant %s\n got %s", want, got)
+			}
+
+			if got, want := r.Header.Get("Content-Type"),
+				"application/json"; got != want {
+				t.Errorf("content type: want %s, got %s", want, got)
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := string(body), `{"gym": 42}`; got != want {
+				t.Errorf("body:\nwant %s\n got %s", want, got)
+			}
+
+			return jsonResponse(`{"people": 1, "capacity": 2}`), nil
+		},
+	}
+
+	source, err := scrape.NewJSONSource(
+		logger(t),
+		httper,
+		fixedClock,
+		scrape.Config{
+			URL:     "http://example.com/gyms/{{.GymID}}?name={{.GymName}}",
+			GymName: "sputnik",
+			GymID:   42,
+		},
+		scrape.JSONConfig{
+			Method:        http.MethodPut,
+			Body:          `{"gym": {{.GymID}}}`,
+			PeopleField:   "people",
+			CapacityField: "capacity",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := source.Scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func jsonResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+	}
+}
+
+func TestJSONSource_Decode(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name          string
+		peopleField   string
+		capacityField string
+		response      string
+		want          *gym.Utilization // nil for errors
+	}{
+		{
+			name:          "top level fields",
+			peopleField:   "people",
+			capacityField: "capacity",
+			response:      `{"people": 1, "capacity": 2}`,
+			want:          &gym.Utilization{People: 1, Capacity: 2},
+		}, {
+			name:          "nested fields with JSONPath root",
+			peopleField:   "$.data.gyms[1].now",
+			capacityField: "$.data.max",
+			response: `{"data": {"max": 200, "gyms": [
+				{"now": 1}, {"now": 42}
+			]}}`,
+			want: &gym.Utilization{People: 42, Capacity: 200},
+		}, {
+			name:          "root array",
+			peopleField:   "[0][1]",
+			capacityField: "[1]",
+			response:      `[[0, 5], 10]`,
+			want:          &gym.Utilization{People: 5, Capacity: 10},
+		}, {
+			name:          "numbers as strings and integral floats",
+			peopleField:   "people",
+			capacityField: "capacity",
+			response:      `{"people": " 7 ", "capacity": 100.0}`,
+			want:          &gym.Utilization{People: 7, Capacity: 100},
+		}, {
+			name:          "missing key",
+			peopleField:   "people",
+			capacityField: "max",
+			response:      `{"people": 1, "capacity": 2}`,
+		}, {
+			name:          "index out of range",
+			peopleField:   "people[3]",
+			capacityField: "capacity",
+			response:      `{"people": [1], "capacity": 2}`,
+		}, {
+			name:          "not a number",
+			peopleField:   "people",
+			capacityField: "capacity",
+			response:      `{"people": true, "capacity": 2}`,
+		}, {
+			name:          "negative number",
+			peopleField:   "people",
+			capacityField: "capacity",
+			response:      `{"people": -1, "capacity": 2}`,
+		}, {
+			name:          "zero capacity",
+			peopleField:   "people",
+			capacityField: "capacity",
+			response:      `{"people": 1, "capacity": 0}`,
+		}, {
+			name:          "invalid JSON",
+			peopleField:   "people",
+			capacityField: "capacity",
+			response:      `{`,
+		},
+	}
+
+	for _, test := range subtests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			httper := mockHTTPer{
+				do: func(r *http.Request) (*http.Response, error) {
+					return jsonResponse(test.response), nil
+				},
+			}
+
+			source, err := scrape.NewJSONSource(
+				logger(t),
+				httper,
+				func() time.Time { return time.Time{} },
+				scrape.Config{},
+				scrape.JSONConfig{
+					PeopleField:   test.peopleField,
+					CapacityField: test.capacityField,
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := source.Scrape(context.Background())
+
+			if test.want == nil {
+				if err == nil {
+					t.Fatalf("unexpected success: %v", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Equal(test.want) {
+				t.Errorf("\nwant %v\n got %v", test.want, got)
+			}
+		})
+	}
+}
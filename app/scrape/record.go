@@ -0,0 +1,239 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Recording is a raw response from a gym endpoint.
+//
+// The body is stored as a string so recordings are easy to read and
+// edit by hand, this means invalid UTF-8 sequences will not survive a
+// round trip through an archive.
+type Recording struct {
+	Gym       string      `json:"gym"`
+	Timestamp time.Time   `json:"timestamp"`
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header"`
+	Body      string      `json:"body"`
+}
+
+// Utilization returns the utilization in the recorded response,
+// decoded with the given function, and the unknown fields found by
+// the decoder. Unsuccessful responses are returned as a StatusError.
+func (r *Recording) utilization(decode decodeFunc) (
+	*gym.Utilization, []string, error) {
+	if r.Status != http.StatusOK {
+		return nil, nil, &StatusError{
+			Code:       r.Status,
+			Body:       r.Body,
+			RetryAfter: retryAfter(r.Header, r.Timestamp),
+		}
+	}
+
+	reading, err := decode([]byte(r.Body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if reading.Capacity == 0 {
+		return nil, nil, fmt.Errorf(
+			"ignoring server response with zero capacity")
+	}
+
+	result := &gym.Utilization{
+		Gym:       r.Gym,
+		Timestamp: r.Timestamp,
+		People:    reading.People,
+		Capacity:  reading.Capacity,
+	}
+
+	return result, reading.UnknownFields, nil
+}
+
+// Recorder knows how to store raw responses, see Archive.
+type Recorder interface {
+	Record(*Recording) error
+}
+
+// Archive is a Recorder that stores each recording as a JSON file in a
+// directory. Once the directory has more than a certain number of
+// recordings, the oldest ones are removed.
+type Archive struct {
+	dir      string
+	maxFiles int
+	mux      sync.Mutex
+}
+
+// recordingExt is the extension of the recording files. Their names
+// are the timestamp of the recording, so sorting them by name sorts
+// them chronologically.
+const (
+	recordingExt        = ".json"
+	recordingNameFormat = "20060102T150405.000000000Z"
+)
+
+// NewArchive returns an archive that stores up to maxFiles recordings
+// in the given directory, creating it if needed.
+func NewArchive(dir string, maxFiles int) (*Archive, error) {
+	if maxFiles < 1 {
+		return nil, fmt.Errorf("max files must be >0, was %d", maxFiles)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating archive directory: %v", err)
+	}
+
+	return &Archive{
+		dir:      dir,
+		maxFiles: maxFiles,
+	}, nil
+}
+
+// Record implements Recorder.
+func (a *Archive) Record(r *Recording) error {
+	b, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding recording: %v", err)
+	}
+
+	name := r.Timestamp.UTC().Format(recordingNameFormat) + recordingExt
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	// write to a temporary file first, so readers never see half
+	// written recordings
+	tmp := filepath.Join(a.dir, "."+name)
+	if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("writing recording: %v", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(a.dir, name)); err != nil {
+		return fmt.Errorf("writing recording: %v", err)
+	}
+
+	return a.rotate()
+}
+
+// Rotate removes the oldest recordings until there are no more than
+// the max number of files. It assumes the mutex is locked.
+func (a *Archive) rotate() error {
+	names, err := recordingNames(a.dir)
+	if err != nil {
+		return err
+	}
+
+	for len(names) > a.maxFiles {
+		if err := os.Remove(filepath.Join(a.dir, names[0])); err != nil {
+			return fmt.Errorf("removing old recording: %v", err)
+		}
+
+		names = names[1:]
+	}
+
+	return nil
+}
+
+// RecordingNames returns the names of the recording files in a
+// directory, sorted chronologically.
+func recordingNames(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing recordings: %v", err)
+	}
+
+	result := []string{}
+
+	for _, info := range infos {
+		name := info.Name()
+
+		if info.IsDir() ||
+			strings.HasPrefix(name, ".") ||
+			filepath.Ext(name) != recordingExt {
+			continue
+		}
+
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// Replay is a source that replays the recordings in an archive
+// directory, in chronological order, decoding them with a decoder, for
+// instance the one from the source that recorded them. Once all the
+// recordings have been replayed, Scrape returns io.EOF.
+//
+// Replaying recordings is useful to reproduce decoding problems
+// offline and to turn the responses of a vendor into test fixtures.
+type Replay struct {
+	dir     string
+	decoder Decoder
+
+	mux   sync.Mutex
+	names []string // the recordings yet to be replayed
+}
+
+// NewReplay returns a Replay for the recordings in the given directory.
+func NewReplay(dir string, decoder Decoder) (*Replay, error) {
+	names, err := recordingNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replay{
+		dir:     dir,
+		decoder: decoder,
+		names:   names,
+	}, nil
+}
+
+// Scrape returns the utilization in the next recording, or an error
+// if it cannot be decoded.
+func (r *Replay) Scrape(ctx context.Context) (*gym.Utilization, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if len(r.names) == 0 {
+		return nil, io.EOF
+	}
+
+	name := r.names[0]
+	r.names = r.names[1:]
+
+	b, err := ioutil.ReadFile(filepath.Join(r.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("reading recording: %v", err)
+	}
+
+	var rec Recording
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("decoding recording %s: %v", name, err)
+	}
+
+	u, _, err := rec.utilization(r.decoder.Decode)
+	if err != nil {
+		return nil, fmt.Errorf("recording %s: %w", name, err)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,178 @@
+package scrape_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+func TestNewArchive_InvalidMaxFiles(t *testing.T) {
+	t.Parallel()
+
+	_, err := scrape.NewArchive(t.TempDir(), 0)
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestArchive_RecordAndReplay(t *testing.T) {
+	t.Parallel()
+
+	const (
+		maxFiles = 3
+		scrapes  = 5
+	)
+
+	dir := filepath.Join(t.TempDir(), "archive")
+
+	archive, err := scrape.NewArchive(dir, maxFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// an httper that returns n people on the nth call, and a clock
+	// that moves a minute on each call.
+	var calls int
+	httper := mockHTTPer{
+		do: func(*http.Request) (*http.Response, error) {
+			calls++
+			body := fmt.Sprintf(`{"People": %d, "Capacity": 100}`, calls)
+
+			return jsonResponse(body), nil
+		},
+	}
+
+	clock := func() time.Time {
+		return start.Add(time.Duration(calls) * time.Minute)
+	}
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		httper,
+		clock,
+		scrape.Config{GymName: "a", Recorder: archive},
+	)
+
+	scraped := []*gym.Utilization{}
+
+	for i := 0; i < scrapes; i++ {
+		u, err := scraper.Scrape(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scraped = append(scraped, u)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != maxFiles {
+		t.Fatalf("want %d files in the archive, got %d",
+			maxFiles, len(files))
+	}
+
+	// only the newest recordings are replayed
+	replay, err := scrape.NewReplay(dir, scraper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := scraped[scrapes-maxFiles:]
+	got := replayAll(t, replay)
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+// replayAll returns the utilizations replayed until io.EOF, failing on
+// any other error.
+func replayAll(t *testing.T, replay *scrape.Replay) []*gym.Utilization {
+	t.Helper()
+
+	result := []*gym.Utilization{}
+
+	for {
+		u, err := replay.Scrape(context.Background())
+		if errors.Is(err, io.EOF) {
+			return result
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result = append(result, u)
+	}
+}
+
+// TestReplay_VendorFixtures replays some real responses from our
+// original vendor through the decoder of the Scraper.
+func TestReplay_VendorFixtures(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join("testdata", "recordings", "vendor")
+
+	scraper := scrape.NewScraper(nil, nil, nil, scrape.Config{})
+
+	replay, err := scrape.NewReplay(dir, scraper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// a good response
+	got, err := replay.Scrape(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &gym.Utilization{
+		Gym:       "sputnik",
+		Timestamp: time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC),
+		People:    42,
+		Capacity:  120,
+	}
+
+	if !got.Equal(want) {
+		t.Errorf("\nwant %v\n got %v", want, got)
+	}
+
+	// an unsuccessful response
+	_, err = replay.Scrape(ctx)
+
+	var statusErr *scrape.StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("want a status error, got %v", err)
+	}
+
+	if statusErr.RetryAfter != 2*time.Minute {
+		t.Errorf("retry after: want 2m, got %v", statusErr.RetryAfter)
+	}
+
+	// a response where the vendor changed their payload
+	if _, err = replay.Scrape(ctx); err == nil {
+		t.Errorf("unexpected success decoding a changed payload")
+	}
+
+	if _, err = replay.Scrape(ctx); !errors.Is(err, io.EOF) {
+		t.Errorf("want io.EOF at the end of the replay, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryConfig controls how the scraper retries failed attempts.
+//
+// Only retryable errors are retried: network errors (like timeouts),
+// 5xx responses and 429 responses. Other errors, like 4xx responses or
+// undecodable responses, are considered permanent and returned right
+// away.
+//
+// The time to wait before each retry grows exponentially from the
+// initial backoff, multiplying it by the multiplier on each attempt, up
+// to the max backoff. A random fraction of each wait, up to the jitter,
+// is removed so several scrapers don't retry in lockstep.
+//
+// If the server asks for a specific wait with a Retry-After header, it
+// will be honored, unless it is longer than the max backoff, in which
+// case the scraper gives up.
+type RetryConfig struct {
+	MaxAttempts    int // the total number of attempts, 0 or 1 disables retries
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64 // values <1 are considered as 1
+	Jitter         float64 // between 0 (no jitter) and 1
+}
+
+// Backoff returns how long to wait before retrying after the given
+// failed attempt (starting from 1) and if it is worth retrying at all.
+// The jitter function must return a random number in [0, 1).
+func (c RetryConfig) backoff(
+	attempt int,
+	err error,
+	jitter func() float64,
+) (time.Duration, bool) {
+	if !retryable(err) {
+		return 0, false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > c.MaxBackoff {
+			return 0, false
+		}
+
+		return statusErr.RetryAfter, true
+	}
+
+	multiplier := c.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	wait := float64(c.InitialBackoff)
+	for i := 1; i < attempt && wait < float64(c.MaxBackoff); i++ {
+		wait *= multiplier
+	}
+
+	if wait > float64(c.MaxBackoff) {
+		wait = float64(c.MaxBackoff)
+	}
+
+	wait -= wait * c.Jitter * jitter()
+
+	return time.Duration(wait), true
+}
+
+// Retryable returns if the error returned by a scraping attempt is
+// worth retrying. The caller must check its own context first: the
+// errors of client timeouts also match context.DeadlineExceeded, so
+// the error can't tell if the caller gave up.
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests ||
+			statusErr.Code >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return false
+}
+
+// StatusError is the error returned when the gym endpoint answers with
+// an unsuccessful status code.
+type StatusError struct {
+	Code       int
+	Body       string
+	RetryAfter time.Duration // zero if the server didn't ask for any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unsuccessful response: status %d (%s); body: %s",
+		e.Code, http.StatusText(e.Code), e.Body)
+}
+
+// RetryAfter returns the wait requested by the Retry-After header,
+// which can be a number of seconds or an HTTP date, or zero if there
+// is no such header or it is invalid. Dates are relative to the given
+// time of the response.
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(v)
+	if err != nil {
+		return 0
+	}
+
+	wait := date.Sub(now)
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// Sleep waits for the given duration or until the context is done,
+// whatever happens first. It returns the context error in the latter
+// case.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
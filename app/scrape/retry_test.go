@@ -0,0 +1,418 @@
+package scrape_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+func TestScrape_Retry(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]func(t *testing.T){
+		"retries retryable errors":             retriesRetryable,
+		"retries client timeouts":              retriesClientTimeouts,
+		"does not retry if the caller gave up": doesNotRetryIfCallerGaveUp,
+		"does not retry permanent errors":      doesNotRetryPermanent,
+		"gives up after max attempts":          givesUpAfterMaxAttempts,
+		"honors retry-after":                   honorsRetryAfter,
+		"gives up on too long retry-after":     givesUpOnLongRetryAfter,
+		"stops waiting if context is done":     stopsWaitingOnCancel,
+		"does not retry if retries disabled":   noRetriesByDefault,
+	}
+
+	for name, testFunc := range subtests {
+		testFunc := testFunc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			testFunc(t)
+		})
+	}
+}
+
+// fakeResponse is a canned response for a sequenceHTTPer.
+type fakeResponse struct {
+	status int
+	header http.Header
+	body   string
+	err    error
+}
+
+// sequenceHTTPer is an HTTPer that returns a sequence of canned
+// responses, repeating the last one once the sequence is exhausted, and
+// counts how many times it has been called.
+type sequenceHTTPer struct {
+	mutex     sync.Mutex
+	responses []fakeResponse
+	calls     int
+}
+
+func (s *sequenceHTTPer) Do(_ *http.Request) (*http.Response, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.calls
+	if i >= len(s.responses) {
+		i = len(s.responses) - 1
+	}
+
+	s.calls++
+
+	r := s.responses[i]
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	header := r.header
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		StatusCode: r.status,
+		Header:     header,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(r.body)),
+	}, nil
+}
+
+func (s *sequenceHTTPer) Calls() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.calls
+}
+
+// fastRetries is a retry config with short waits, for tests.
+func fastRetries(maxAttempts int) scrape.RetryConfig {
+	return scrape.RetryConfig{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+func fixedClock() time.Time {
+	return time.Time{}.Add(time.Second)
+}
+
+var (
+	okResponse = fakeResponse{
+		status: http.StatusOK,
+		body:   `{"People": 1, "Capacity": 2}`,
+	}
+	unavailableResponse = fakeResponse{
+		status: http.StatusServiceUnavailable,
+		body:   "try again later",
+	}
+)
+
+func retriesRetryable(t *testing.T) {
+	httper := &sequenceHTTPer{
+		responses: []fakeResponse{
+			unavailableResponse,
+			{status: http.StatusTooManyRequests},
+			okResponse,
+		},
+	}
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		httper,
+		fixedClock,
+		scrape.Config{Retry: fastRetries(10)},
+	)
+
+	if _, err := scraper.Scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := httper.Calls(), 3; got != want {
+		t.Errorf("calls: want %d, got %d", want, got)
+	}
+}
+
+// stallingServer returns a server that doesn't answer the first
+// stalled requests until they are cancelled or the test ends, and
+// answers the rest with the ok response. It also returns the number of
+// requests received so far.
+func stallingServer(t *testing.T, stalled int32) (*httptest.Server, func() int32) {
+	t.Helper()
+
+	var requests int32
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// the server only notices the client going away once
+			// the body has been read
+			_, _ = io.Copy(ioutil.Discard, r.Body)
+
+			if atomic.AddInt32(&requests, 1) <= stalled {
+				select {
+				case <-r.Context().Done():
+				case <-release:
+				}
+
+				return
+			}
+
+			_, _ = io.WriteString(w, okResponse.body)
+		}))
+
+	// cleanups run in reverse order, release the stalled requests
+	// before closing the server
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	return server, func() int32 { return atomic.LoadInt32(&requests) }
+}
+
+func retriesClientTimeouts(t *testing.T) {
+	server, requests := stallingServer(t, 1)
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		client,
+		fixedClock,
+		scrape.Config{URL: server.URL, Retry: fastRetries(10)},
+	)
+
+	if _, err := scraper.Scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := requests(), int32(2); got != want {
+		t.Errorf("requests: want %d, got %d", want, got)
+	}
+}
+
+func doesNotRetryIfCallerGaveUp(t *testing.T) {
+	server, requests := stallingServer(t, math.MaxInt32)
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		&http.Client{},
+		fixedClock,
+		scrape.Config{URL: server.URL, Retry: fastRetries(10)},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+
+	_, err := scraper.Scrape(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want a deadline exceeded error, got %v", err)
+	}
+
+	if got, want := requests(), int32(1); got != want {
+		t.Errorf("requests: want %d, got %d", want, got)
+	}
+}
+
+func doesNotRetryPermanent(t *testing.T) {
+	subtests := map[string]fakeResponse{
+		"4xx":                    {status: http.StatusNotFound},
+		"undecodable response":   {status: http.StatusOK, body: "{"},
+		"zero capacity response": {status: http.StatusOK, body: "{}"},
+		"non-network error":      {err: errors.New("some error")},
+	}
+
+	for name, response := range subtests {
+		response := response
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			httper := &sequenceHTTPer{
+				responses: []fakeResponse{response, okResponse},
+			}
+
+			scraper := scrape.NewScraper(
+				logger(t),
+				httper,
+				fixedClock,
+				scrape.Config{Retry: fastRetries(10)},
+			)
+
+			if _, err := scraper.Scrape(context.Background()); err == nil {
+				t.Fatal("unexpected success")
+			}
+
+			if got, want := httper.Calls(), 1; got != want {
+				t.Errorf("calls: want %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func givesUpAfterMaxAttempts(t *testing.T) {
+	httper := &sequenceHTTPer{
+		responses: []fakeResponse{unavailableResponse},
+	}
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		httper,
+		fixedClock,
+		scrape.Config{Retry: fastRetries(3)},
+	)
+
+	_, err := scraper.Scrape(context.Background())
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	var statusErr *scrape.StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("want a status error, got %v", err)
+	}
+
+	if statusErr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status code: want %d, got %d",
+			http.StatusServiceUnavailable, statusErr.Code)
+	}
+
+	if got, want := httper.Calls(), 3; got != want {
+		t.Errorf("calls: want %d, got %d", want, got)
+	}
+}
+
+func honorsRetryAfter(t *testing.T) {
+	const wait = 20 * time.Millisecond
+
+	// HTTP dates have a resolution of seconds, so we ask to retry at a
+	// whole second and use a clock that is a little before that.
+	retryAt := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return retryAt.Add(-wait) }
+
+	httper := &sequenceHTTPer{
+		responses: []fakeResponse{
+			{
+				status: http.StatusTooManyRequests,
+				header: http.Header{
+					"Retry-After": []string{
+						retryAt.Format(http.TimeFormat),
+					},
+				},
+			},
+			okResponse,
+		},
+	}
+
+	config := scrape.Config{Retry: fastRetries(2)}
+	config.Retry.MaxBackoff = time.Minute
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		httper,
+		clock,
+		config,
+	)
+
+	start := time.Now()
+
+	if _, err := scraper.Scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < wait {
+		t.Errorf("retried too soon: after %v, want at least %v",
+			elapsed, wait)
+	}
+}
+
+func givesUpOnLongRetryAfter(t *testing.T) {
+	httper := &sequenceHTTPer{
+		responses: []fakeResponse{
+			{
+				status: http.StatusTooManyRequests,
+				header: http.Header{"Retry-After": []string{"3600"}},
+			},
+			okResponse,
+		},
+	}
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		httper,
+		fixedClock,
+		scrape.Config{Retry: fastRetries(10)},
+	)
+
+	if _, err := scraper.Scrape(context.Background()); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if got, want := httper.Calls(), 1; got != want {
+		t.Errorf("calls: want %d, got %d", want, got)
+	}
+}
+
+func stopsWaitingOnCancel(t *testing.T) {
+	httper := &sequenceHTTPer{
+		responses: []fakeResponse{unavailableResponse, okResponse},
+	}
+
+	config := scrape.Config{Retry: fastRetries(2)}
+	config.Retry.InitialBackoff = time.Hour
+	config.Retry.MaxBackoff = time.Hour
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		httper,
+		fixedClock,
+		config,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+
+	_, err := scraper.Scrape(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want a deadline exceeded error, got %v", err)
+	}
+
+	if got, want := httper.Calls(), 1; got != want {
+		t.Errorf("calls: want %d, got %d", want, got)
+	}
+}
+
+func noRetriesByDefault(t *testing.T) {
+	httper := &sequenceHTTPer{
+		responses: []fakeResponse{unavailableResponse, okResponse},
+	}
+
+	scraper := scrape.NewScraper(
+		logger(t),
+		httper,
+		fixedClock,
+		scrape.Config{},
+	)
+
+	if _, err := scraper.Scrape(context.Background()); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if got, want := httper.Calls(), 1; got != want {
+		t.Errorf("calls: want %d, got %d", want, got)
+	}
+}
@@ -4,27 +4,35 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/alcortesm/sputnik-popularity/app/gym"
 )
 
+// Config is the configuration shared by all the HTTP sources.
 type Config struct {
-	URL     string
-	GymName string
-	GymID   int
+	URL      string
+	GymName  string
+	GymID    int
+	Retry    RetryConfig
+	Recorder Recorder // optional, to record the raw responses
+	// Validator is optional, to validate the scraped values. Sources
+	// should not share validators.
+	Validator *Validator
+	Tracker   AttemptTracker // optional, to track every attempt
 }
 
+// Scraper is the source for gyms using the booking vendor we started
+// with: it POSTs the gym namespace and ID as JSON and gets back the
+// people and capacity as JSON.
 type Scraper struct {
-	logger *log.Logger
-	client HTTPer
-	clock  Clock
-	url    string
-	body   string
+	fetcher fetcher
+	url     string
+	body    string
 }
 
 type HTTPer interface {
@@ -44,15 +52,20 @@ func NewScraper(
 	)
 
 	return &Scraper{
-		logger: logger,
-		client: client,
-		clock:  clock,
-		url:    config.URL,
-		body:   body,
+		fetcher: newFetcher(logger, client, clock, config),
+		url:     config.URL,
+		body:    body,
 	}
 }
 
+// Scrape asks the gym endpoint for its current utilization. Failed
+// attempts are retried according to the retry configuration of the
+// scraper if the error is retryable, see RetryConfig.
 func (s *Scraper) Scrape(ctx context.Context) (*gym.Utilization, error) {
+	return s.fetcher.scrape(ctx, s.request, s.Decode)
+}
+
+func (s *Scraper) request(ctx context.Context) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodPost,
@@ -60,50 +73,46 @@ func (s *Scraper) Scrape(ctx context.Context) (*gym.Utilization, error) {
 		strings.NewReader(s.body),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("creating request: %v", err)
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed POST %s: %v", s.url, err)
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"status %d (%s); error reading response body: %v",
-				resp.StatusCode, http.StatusText(resp.StatusCode), err)
-		}
-
-		return nil, fmt.Errorf(
-			"unsuccessful response: status %d (%s); body: %s",
-			resp.StatusCode, http.StatusText(resp.StatusCode), body,
-		)
-	}
+	return req, nil
+}
 
+// Decode implements Decoder.
+func (s *Scraper) Decode(body []byte) (Reading, error) {
 	var response struct {
 		People   uint64
 		Capacity uint64
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("decoding response: %v", err)
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Reading{}, fmt.Errorf("decoding response: %v", err)
 	}
 
-	if response.Capacity == 0 {
-		return nil, fmt.Errorf("ignoring server response with zero capacity")
+	result := Reading{
+		People:   response.People,
+		Capacity: response.Capacity,
 	}
 
-	result := &gym.Utilization{
-		Timestamp: s.clock(),
-		People:    response.People,
-		Capacity:  response.Capacity,
+	// look for fields we don't know about, they can be a sign that
+	// the vendor has changed their payload
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return Reading{}, fmt.Errorf("decoding response: %v", err)
 	}
 
+	for name := range fields {
+		// encoding/json matches field names case-insensitively
+		if !strings.EqualFold(name, "People") &&
+			!strings.EqualFold(name, "Capacity") {
+			result.UnknownFields = append(result.UnknownFields, name)
+		}
+	}
+
+	sort.Strings(result.UnknownFields)
+
 	return result, nil
 }
@@ -65,10 +65,12 @@ func (m mockHTTPer) Do(r *http.Request) (*http.Response, error) {
 
 func correct(t *testing.T) {
 	fix := struct {
+		gymName   string
 		people    uint64
 		capacity  uint64
 		timestamp time.Time
 	}{
+		gymName:   "some_gymName",
 		people:    42,
 		capacity:  200,
 		timestamp: time.Time{}.Add(time.Second),
@@ -101,7 +103,7 @@ func correct(t *testing.T) {
 		logger(t),
 		httper,
 		clock,
-		scrape.Config{}, // irrelevant config
+		scrape.Config{GymName: fix.gymName},
 	)
 
 	got, err := scraper.Scrape(context.Background())
@@ -110,6 +112,7 @@ func correct(t *testing.T) {
 	}
 
 	want := &gym.Utilization{
+		Gym:       fix.gymName,
 		Timestamp: fix.timestamp,
 		People:    fix.people,
 		Capacity:  fix.capacity,
@@ -176,7 +179,7 @@ func httperNonOKResponse(t *testing.T) {
 	scraper := scrape.NewScraper(
 		logger(t),
 		httper,
-		nil,             // irrelevant clock
+		fixedClock,
 		scrape.Config{}, // irrelevant config
 	)
 
@@ -0,0 +1,17 @@
+package scrape
+
+import (
+	"context"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Source is anything that knows how to scrape gym utilization data.
+//
+// Gyms using different booking vendors need different sources: Scraper
+// talks to the vendor we started with, JSONSource can be configured to
+// talk to most JSON APIs and HTMLSource extracts the utilization from
+// web pages.
+type Source interface {
+	Scrape(context.Context) (*gym.Utilization, error)
+}
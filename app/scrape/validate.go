@@ -0,0 +1,216 @@
+package scrape
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Verdict is the result of validating a value.
+type Verdict int
+
+const (
+	// Accepted values look fine.
+	Accepted Verdict = iota
+	// Suspicious values look wrong, but not wrong enough to be
+	// discarded: they are stored but flagged.
+	Suspicious
+	// Rejected values are discarded.
+	Rejected
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Accepted:
+		return "accepted"
+	case Suspicious:
+		return "suspicious"
+	case Rejected:
+		return "rejected"
+	default:
+		return fmt.Sprintf("unknown verdict (%d)", int(v))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (v Verdict) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting the
+// names returned by String.
+func (v *Verdict) UnmarshalText(text []byte) error {
+	for _, candidate := range []Verdict{Accepted, Suspicious, Rejected} {
+		if string(text) == candidate.String() {
+			*v = candidate
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown verdict %q", text)
+}
+
+// RejectedError is returned for values rejected by a validator.
+type RejectedError struct {
+	Value   *gym.Utilization
+	Reasons []string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected value %v: %s",
+		e.Value, strings.Join(e.Reasons, "; "))
+}
+
+// Rule is a validation rule: a check and the verdict for the values
+// that fail it.
+type Rule struct {
+	verdict Verdict
+	// check returns why the value breaks the rule, or an empty string
+	// if it doesn't. The previous value is nil for the first value.
+	check func(prev, u *gym.Utilization, unknownFields []string) string
+}
+
+// MaxPeopleRatio is a rule for values with more people than the given
+// ratio of their capacity, like 1.2 for 120%.
+func MaxPeopleRatio(ratio float64, verdict Verdict) Rule {
+	return Rule{
+		verdict: verdict,
+		check: func(_, u *gym.Utilization, _ []string) string {
+			if float64(u.People) <= ratio*float64(u.Capacity) {
+				return ""
+			}
+
+			return fmt.Sprintf("%d people is more than %.2f times "+
+				"the capacity (%d)", u.People, ratio, u.Capacity)
+		},
+	}
+}
+
+// MaxDeltaPerMinute is a rule for values whose people change faster
+// than the given number of people per minute, compared with the
+// previous value.
+func MaxDeltaPerMinute(delta float64, verdict Verdict) Rule {
+	return Rule{
+		verdict: verdict,
+		check: func(prev, u *gym.Utilization, _ []string) string {
+			if prev == nil {
+				return ""
+			}
+
+			minutes := u.Timestamp.Sub(prev.Timestamp).Minutes()
+			if minutes <= 0 {
+				return ""
+			}
+
+			change := math.Abs(float64(u.People) - float64(prev.People))
+			if change/minutes <= delta {
+				return ""
+			}
+
+			return fmt.Sprintf("people changed from %d to %d in %.1f "+
+				"minutes, more than %.2f per minute",
+				prev.People, u.People, minutes, delta)
+		},
+	}
+}
+
+// CapacityRange is a rule for values with a capacity outside the given
+// range. A zero max means no upper bound.
+func CapacityRange(min, max uint64, verdict Verdict) Rule {
+	return Rule{
+		verdict: verdict,
+		check: func(_, u *gym.Utilization, _ []string) string {
+			if u.Capacity >= min && (max == 0 || u.Capacity <= max) {
+				return ""
+			}
+
+			return fmt.Sprintf("capacity %d is outside the allowed "+
+				"range [%d, %d]", u.Capacity, min, max)
+		},
+	}
+}
+
+// StableCapacity is a rule for values whose capacity is different from
+// the capacity of the previous value.
+func StableCapacity(verdict Verdict) Rule {
+	return Rule{
+		verdict: verdict,
+		check: func(prev, u *gym.Utilization, _ []string) string {
+			if prev == nil || prev.Capacity == u.Capacity {
+				return ""
+			}
+
+			return fmt.Sprintf("capacity changed from %d to %d",
+				prev.Capacity, u.Capacity)
+		},
+	}
+}
+
+// NoUnknownFields is a rule for values decoded from responses with
+// fields the decoder doesn't know about.
+func NoUnknownFields(verdict Verdict) Rule {
+	return Rule{
+		verdict: verdict,
+		check: func(_, _ *gym.Utilization, unknownFields []string) string {
+			if len(unknownFields) == 0 {
+				return ""
+			}
+
+			return fmt.Sprintf("unknown fields in response: %s",
+				strings.Join(unknownFields, ", "))
+		},
+	}
+}
+
+// Validator checks values from a gym against a set of rules. The
+// verdict for a value is the most severe verdict of all the rules it
+// breaks, or Accepted if it doesn't break any.
+//
+// Validators remember the last value they didn't reject, to compare it
+// with the next one, so each gym needs its own validator and values
+// should be validated in chronological order.
+type Validator struct {
+	rules []Rule
+
+	mux  sync.Mutex
+	prev *gym.Utilization
+}
+
+// NewValidator returns a validator with the given rules.
+func NewValidator(rules ...Rule) *Validator {
+	return &Validator{rules: rules}
+}
+
+// Validate returns the verdict for the value and the reasons for it.
+func (v *Validator) Validate(
+	u *gym.Utilization,
+	unknownFields []string,
+) (Verdict, []string) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	verdict := Accepted
+	reasons := []string{}
+
+	for _, r := range v.rules {
+		reason := r.check(v.prev, u, unknownFields)
+		if reason == "" {
+			continue
+		}
+
+		reasons = append(reasons, reason)
+
+		if r.verdict > verdict {
+			verdict = r.verdict
+		}
+	}
+
+	if verdict != Rejected {
+		v.prev = u
+	}
+
+	return verdict, reasons
+}
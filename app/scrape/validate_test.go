@@ -0,0 +1,229 @@
+package scrape_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+)
+
+func TestValidator(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+	at := func(minutes int, people, capacity uint64) *gym.Utilization {
+		return &gym.Utilization{
+			Timestamp: t0.Add(time.Duration(minutes) * time.Minute),
+			People:    people,
+			Capacity:  capacity,
+		}
+	}
+
+	type step struct {
+		value   *gym.Utilization
+		unknown []string
+		want    scrape.Verdict
+	}
+
+	subtests := map[string]struct {
+		rules []scrape.Rule
+		steps []step
+	}{
+		"no rules": {
+			steps: []step{
+				{value: at(0, 500, 10), want: scrape.Accepted},
+			},
+		},
+		"max people ratio": {
+			rules: []scrape.Rule{
+				scrape.MaxPeopleRatio(1.2, scrape.Rejected),
+			},
+			steps: []step{
+				{value: at(0, 12, 10), want: scrape.Accepted},
+				{value: at(1, 13, 10), want: scrape.Rejected},
+			},
+		},
+		"max delta per minute": {
+			rules: []scrape.Rule{
+				scrape.MaxDeltaPerMinute(2, scrape.Suspicious),
+			},
+			steps: []step{
+				{value: at(0, 10, 100), want: scrape.Accepted},
+				{value: at(10, 30, 100), want: scrape.Accepted},
+				{value: at(11, 33, 100), want: scrape.Suspicious},
+				// compared with the suspicious value
+				{value: at(12, 1, 100), want: scrape.Suspicious},
+				{value: at(22, 0, 100), want: scrape.Accepted},
+			},
+		},
+		"rejected values are forgotten": {
+			rules: []scrape.Rule{
+				scrape.MaxDeltaPerMinute(2, scrape.Rejected),
+			},
+			steps: []step{
+				{value: at(0, 10, 100), want: scrape.Accepted},
+				{value: at(1, 90, 100), want: scrape.Rejected},
+				{value: at(2, 12, 100), want: scrape.Accepted},
+			},
+		},
+		"capacity range": {
+			rules: []scrape.Rule{
+				scrape.CapacityRange(10, 100, scrape.Rejected),
+			},
+			steps: []step{
+				{value: at(0, 1, 9), want: scrape.Rejected},
+				{value: at(1, 1, 10), want: scrape.Accepted},
+				{value: at(2, 1, 100), want: scrape.Accepted},
+				{value: at(3, 1, 101), want: scrape.Rejected},
+			},
+		},
+		"capacity range without max": {
+			rules: []scrape.Rule{
+				scrape.CapacityRange(10, 0, scrape.Rejected),
+			},
+			steps: []step{
+				{value: at(0, 1, 1000), want: scrape.Accepted},
+			},
+		},
+		"stable capacity": {
+			rules: []scrape.Rule{
+				scrape.StableCapacity(scrape.Suspicious),
+			},
+			steps: []step{
+				{value: at(0, 1, 100), want: scrape.Accepted},
+				{value: at(1, 1, 100), want: scrape.Accepted},
+				{value: at(2, 1, 120), want: scrape.Suspicious},
+				{value: at(3, 1, 120), want: scrape.Accepted},
+			},
+		},
+		"no unknown fields": {
+			rules: []scrape.Rule{
+				scrape.NoUnknownFields(scrape.Suspicious),
+			},
+			steps: []step{
+				{value: at(0, 1, 100), want: scrape.Accepted},
+				{
+					value:   at(1, 1, 100),
+					unknown: []string{"Waiting"},
+					want:    scrape.Suspicious,
+				},
+			},
+		},
+		"the most severe verdict wins": {
+			rules: []scrape.Rule{
+				scrape.NoUnknownFields(scrape.Suspicious),
+				scrape.MaxPeopleRatio(1, scrape.Rejected),
+			},
+			steps: []step{
+				{
+					value:   at(0, 200, 100),
+					unknown: []string{"Waiting"},
+					want:    scrape.Rejected,
+				},
+			},
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			v := scrape.NewValidator(test.rules...)
+
+			for i, s := range test.steps {
+				got, reasons := v.Validate(s.value, s.unknown)
+				if got != s.want {
+					t.Fatalf("step %d: want %v, got %v (%v)",
+						i, s.want, got, reasons)
+				}
+
+				if got == scrape.Accepted && len(reasons) != 0 {
+					t.Errorf("step %d: unexpected reasons: %v",
+						i, reasons)
+				}
+
+				if got != scrape.Accepted && len(reasons) == 0 {
+					t.Errorf("step %d: missing reasons", i)
+				}
+			}
+		})
+	}
+}
+
+func TestScraperValidation(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]struct {
+		body           string
+		wantErr        bool
+		wantSuspicious bool
+	}{
+		"accepted": {
+			body: `{"People":10,"Capacity":100}`,
+		},
+		"suspicious": {
+			body:           `{"People":10,"Capacity":100,"Waiting":3}`,
+			wantSuspicious: true,
+		},
+		"rejected": {
+			body:    `{"People":150,"Capacity":100}`,
+			wantErr: true,
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			client := &sequenceHTTPer{
+				responses: []fakeResponse{
+					{status: http.StatusOK, body: test.body},
+				},
+			}
+
+			config := scrape.Config{
+				Retry: fastRetries(3),
+				Validator: scrape.NewValidator(
+					scrape.MaxPeopleRatio(1, scrape.Rejected),
+					scrape.NoUnknownFields(scrape.Suspicious),
+				),
+			}
+
+			scraper := scrape.NewScraper(
+				logger(t), client, fixedClock, config)
+
+			got, err := scraper.Scrape(context.Background())
+			if test.wantErr {
+				var rejected *scrape.RejectedError
+				if !errors.As(err, &rejected) {
+					t.Fatalf("want a RejectedError, got %v", err)
+				}
+
+				if client.Calls() != 1 {
+					t.Errorf("rejected values should not be retried, "+
+						"got %d calls", client.Calls())
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Suspicious != test.wantSuspicious {
+				t.Errorf("want suspicious %t, got %t",
+					test.wantSuspicious, got.Suspicious)
+			}
+		})
+	}
+}
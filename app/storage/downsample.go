@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Downsample aggregates the values of each gym in time windows, like
+// Aggregator.GetAggregated, for stores that can't do it themselves.
+// Windows are aligned to the Unix epoch, like the ones of InfluxDB.
+//
+// The result is sorted by gym name and then chronologically.
+func Downsample(
+	data []*gym.Utilization,
+	window time.Duration,
+	fn Aggregate,
+) ([]*gym.Utilization, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid window %v: must be >0", window)
+	}
+
+	if err := fn.Validate(); err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		gym   string
+		start int64
+	}
+
+	keys := []key{}
+	groups := map[key][]*gym.Utilization{}
+
+	for _, d := range data {
+		start := WindowStart(d.Timestamp, window)
+		k := key{gym: d.Gym, start: start.UnixNano()}
+
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+
+		groups[k] = append(groups[k], d)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].gym != keys[j].gym {
+			return keys[i].gym < keys[j].gym
+		}
+
+		return keys[i].start < keys[j].start
+	})
+
+	result := make([]*gym.Utilization, len(keys))
+
+	for i, k := range keys {
+		people, capacity := aggregate(groups[k], fn)
+
+		result[i] = &gym.Utilization{
+			Gym:       k.gym,
+			Timestamp: time.Unix(0, k.start).UTC(),
+			People:    people,
+			Capacity:  capacity,
+		}
+	}
+
+	return result, nil
+}
+
+// WindowStart returns the start of the time window of the given
+// duration that contains the given time, in UTC. Windows are aligned to
+// the Unix epoch.
+func WindowStart(t time.Time, window time.Duration) time.Time {
+	ns := t.UnixNano()
+
+	mod := ns % int64(window)
+	if mod < 0 {
+		mod += int64(window)
+	}
+
+	return time.Unix(0, ns-mod).UTC()
+}
+
+// aggregate returns the people and capacity that result of applying
+// the function to the values of a window, in chronological order.
+// Means are rounded to the nearest integer.
+func aggregate(data []*gym.Utilization, fn Aggregate) (uint64, uint64) {
+	people, capacity := data[0].People, data[0].Capacity
+	var sumPeople, sumCapacity float64
+
+	for _, d := range data {
+		switch fn {
+		case Mean:
+			sumPeople += float64(d.People)
+			sumCapacity += float64(d.Capacity)
+		case Max:
+			if d.People > people {
+				people = d.People
+			}
+
+			if d.Capacity > capacity {
+				capacity = d.Capacity
+			}
+		case Min:
+			if d.People < people {
+				people = d.People
+			}
+
+			if d.Capacity < capacity {
+				capacity = d.Capacity
+			}
+		case Last:
+			people, capacity = d.People, d.Capacity
+		}
+	}
+
+	if fn == Mean {
+		n := float64(len(data))
+		people = uint64(math.Round(sumPeople / n))
+		capacity = uint64(math.Round(sumCapacity / n))
+	}
+
+	return people, capacity
+}
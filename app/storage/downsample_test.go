@@ -0,0 +1,81 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+)
+
+func TestDownsample(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+	at := func(minutes int) time.Time {
+		return t0.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	data := []*gym.Utilization{
+		{Gym: "b", Timestamp: at(5), People: 7, Capacity: 20},
+		{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10},
+		{Gym: "a", Timestamp: at(2), People: 4, Capacity: 12, Suspicious: true},
+		{Gym: "a", Timestamp: at(9), People: 2, Capacity: 10},
+		{Gym: "a", Timestamp: at(10), People: 5, Capacity: 10},
+	}
+
+	subtests := map[storage.Aggregate][]*gym.Utilization{
+		storage.Mean: {
+			{Gym: "a", Timestamp: at(0), People: 2, Capacity: 11},
+			{Gym: "a", Timestamp: at(10), People: 5, Capacity: 10},
+			{Gym: "b", Timestamp: at(0), People: 7, Capacity: 20},
+		},
+		storage.Max: {
+			{Gym: "a", Timestamp: at(0), People: 4, Capacity: 12},
+			{Gym: "a", Timestamp: at(10), People: 5, Capacity: 10},
+			{Gym: "b", Timestamp: at(0), People: 7, Capacity: 20},
+		},
+		storage.Min: {
+			{Gym: "a", Timestamp: at(0), People: 1, Capacity: 10},
+			{Gym: "a", Timestamp: at(10), People: 5, Capacity: 10},
+			{Gym: "b", Timestamp: at(0), People: 7, Capacity: 20},
+		},
+		storage.Last: {
+			{Gym: "a", Timestamp: at(0), People: 2, Capacity: 10},
+			{Gym: "a", Timestamp: at(10), People: 5, Capacity: 10},
+			{Gym: "b", Timestamp: at(0), People: 7, Capacity: 20},
+		},
+	}
+
+	for fn, want := range subtests {
+		fn, want := fn, want
+
+		t.Run(string(fn), func(t *testing.T) {
+			t.Parallel()
+
+			got, err := storage.Downsample(data, 10*time.Minute, fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("(-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDownsample_Errors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := storage.Downsample(nil, 0, storage.Mean); err == nil {
+		t.Error("zero window: unexpected success")
+	}
+
+	if _, err := storage.Downsample(nil, time.Minute, "median"); err == nil {
+		t.Error("unknown function: unexpected success")
+	}
+}
@@ -0,0 +1,95 @@
+// Package storage defines the interface of the permanent stores of gym
+// utilization data, so the app can use any of them.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+)
+
+// Store is a permanent store of gym utilization data, see influx.Store
+// and boltdb.Store.
+//
+// Adding a value with the same gym and timestamp as a stored one
+// overwrites it. The values returned by Get and GetRange are sorted
+// chronologically for each gym, but values from different gyms can be
+// interleaved in any way.
+type Store interface {
+	Add(ctx context.Context, data ...*gym.Utilization) error
+	// Get returns the values since the given time.
+	Get(ctx context.Context, since time.Time) ([]*gym.Utilization, error)
+	// GetRange returns the values from start (inclusive) to end
+	// (exclusive).
+	GetRange(ctx context.Context, start, end time.Time) (
+		[]*gym.Utilization, error)
+}
+
+// PermanentError is the error of a store that will never accept some
+// values, like invalid points or the ones that conflict with its
+// schema, so adding them again is pointless.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Aggregate is a function to summarize the values of a gym in a time
+// window, like its mean.
+type Aggregate string
+
+// The supported aggregate functions.
+const (
+	Mean Aggregate = "mean"
+	Max  Aggregate = "max"
+	Min  Aggregate = "min"
+	Last Aggregate = "last"
+)
+
+// Validate returns an error if the function is not supported.
+func (a Aggregate) Validate() error {
+	switch a {
+	case Mean, Max, Min, Last:
+		return nil
+	default:
+		return fmt.Errorf("unknown aggregate function %q, "+
+			"want one of mean, max, min or last", string(a))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it fails for
+// unsupported functions.
+func (a *Aggregate) UnmarshalText(text []byte) error {
+	v := Aggregate(text)
+	if err := v.Validate(); err != nil {
+		return err
+	}
+
+	*a = v
+
+	return nil
+}
+
+// Aggregator is a store that can summarize its values in time windows,
+// to read long time ranges without reading all their values.
+type Aggregator interface {
+	// GetAggregated returns, for each gym, a value for each time window
+	// from start (inclusive) to end (exclusive) that has values. The
+	// people and capacity of each window are the result of applying
+	// the function to the values in the window, and its timestamp is
+	// the start of the window.
+	GetAggregated(
+		ctx context.Context,
+		start, end time.Time,
+		window time.Duration,
+		fn Aggregate,
+	) ([]*gym.Utilization, error)
+}
@@ -0,0 +1,355 @@
+// Package wal implements a write-ahead queue that persists gym
+// utilization values on disk until a store accepts them.
+package wal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+	"github.com/alcortesm/sputnik-popularity/pkg/atomicfile"
+)
+
+// Adder knows how to add gym utilization data to a store, see
+// influx.Store.
+type Adder interface {
+	Add(context.Context, ...*gym.Utilization) error
+}
+
+// Config controls how the queue retries when its store fails.
+type Config struct {
+	// Dir is where the pending values are persisted. It is created if
+	// it doesn't exist.
+	Dir string
+	// the wait after a failure starts at MinBackoff and doubles after
+	// each consecutive failure, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Queue is a write-ahead queue in front of a store: values added to
+// the queue are persisted on disk right away and then forwarded to the
+// store, in the order they were added, by Run.
+//
+// If the store fails, Run waits and tries again with the same values,
+// so they are never lost or reordered, even across restarts: a new
+// queue with the same directory resumes forwarding the values that
+// were pending. The values the store will never accept, the ones it
+// fails with a storage.PermanentError, are not retried.
+//
+// Each call to Add is persisted as a segment file in the directory,
+// named after its sequence number. Corrupt segments are renamed with a
+// ".corrupt" extension and skipped, and so are the segments rejected
+// by the store, with a ".rejected" extension.
+type Queue struct {
+	logger *log.Logger
+	store  Adder
+	config Config
+	added  chan struct{} // signals Run when new values are added
+
+	mux     sync.Mutex
+	pending []segment // oldest first
+	next    uint64    // the sequence number of the next segment
+	depth   int       // total number of pending values
+}
+
+// Segment is a pending segment file.
+type segment struct {
+	name  string
+	count int // number of values
+}
+
+// segmentFile is the content of segment files.
+type segmentFile struct {
+	Version int
+	Data    []*gym.Utilization
+}
+
+const (
+	segmentVersion = 1
+	segmentExt     = ".json"
+	corruptExt     = ".corrupt"
+	rejectedExt    = ".rejected"
+)
+
+// NewQueue returns a queue for the given store, loading the pending
+// segments from its directory, if any.
+func NewQueue(
+	logger *log.Logger,
+	store Adder,
+	config Config,
+) (*Queue, error) {
+	if config.MinBackoff <= 0 || config.MaxBackoff < config.MinBackoff {
+		return nil, fmt.Errorf("invalid backoffs: min %v, max %v",
+			config.MinBackoff, config.MaxBackoff)
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating directory: %v", err)
+	}
+
+	q := &Queue{
+		logger: logger,
+		store:  store,
+		config: config,
+		added:  make(chan struct{}, 1),
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Load reads the pending segments from the directory.
+func (q *Queue) load() error {
+	infos, err := ioutil.ReadDir(q.config.Dir)
+	if err != nil {
+		return fmt.Errorf("listing segments: %v", err)
+	}
+
+	names := []string{}
+
+	for _, info := range infos {
+		name := info.Name()
+
+		if info.IsDir() ||
+			strings.HasPrefix(name, ".") ||
+			filepath.Ext(name) != segmentExt {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	// names have a fixed width, so this sorts them by sequence number
+	sort.Strings(names)
+
+	for _, name := range names {
+		seq, err := strconv.ParseUint(
+			strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			q.discard(name, corruptExt, fmt.Errorf("invalid name: %v", err))
+			continue
+		}
+
+		data, err := q.read(name)
+		if err != nil {
+			q.discard(name, corruptExt, err)
+			continue
+		}
+
+		q.pending = append(q.pending, segment{name: name, count: len(data)})
+		q.depth += len(data)
+		q.next = seq + 1
+	}
+
+	return nil
+}
+
+// Add persists the values in the queue, to be forwarded to the store
+// by Run.
+func (q *Queue) Add(_ context.Context, data ...*gym.Utilization) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(segmentFile{
+		Version: segmentVersion,
+		Data:    data,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding segment: %v", err)
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	name := fmt.Sprintf("%020d%s", q.next, segmentExt)
+
+	// a crash never leaves a half written segment behind
+	path := filepath.Join(q.config.Dir, name)
+	if err := atomicfile.WriteFile(path, b, 0o644); err != nil {
+		return fmt.Errorf("writing segment: %v", err)
+	}
+
+	q.next++
+	q.pending = append(q.pending, segment{name: name, count: len(data)})
+	q.depth += len(data)
+
+	select {
+	case q.added <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Depth returns the number of values waiting to be forwarded to the
+// store.
+func (q *Queue) Depth() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.depth
+}
+
+// Run forwards the pending values to the store until the context is
+// canceled.
+func (q *Queue) Run(ctx context.Context) error {
+	backoff := time.Duration(0) // zero while the store is healthy
+
+	for {
+		s, ok := q.oldest()
+		if !ok {
+			select {
+			case <-q.added:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		err := q.forward(ctx, s)
+		if err == nil {
+			if backoff != 0 {
+				q.logger.Printf("write-ahead queue: store recovered, "+
+					"%d values pending", q.Depth())
+			}
+
+			backoff = 0
+
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if backoff == 0 {
+			// only log the first failure, to avoid flooding the logs
+			// during long outages
+			q.logger.Printf("write-ahead queue: %v; retrying until the "+
+				"store recovers, %d values pending", err, q.Depth())
+
+			backoff = q.config.MinBackoff
+		} else {
+			backoff *= 2
+			if backoff > q.config.MaxBackoff {
+				backoff = q.config.MaxBackoff
+			}
+		}
+
+		t := time.NewTimer(backoff)
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Oldest returns the oldest pending segment, if any.
+func (q *Queue) oldest() (segment, bool) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if len(q.pending) == 0 {
+		return segment{}, false
+	}
+
+	return q.pending[0], true
+}
+
+// Forward sends the values in the segment to the store and removes
+// the segment once the store accepts them. Corrupt segments and the
+// ones the store rejects permanently are discarded.
+func (q *Queue) forward(ctx context.Context, s segment) error {
+	data, err := q.read(s.name)
+	if err != nil {
+		q.discard(s.name, corruptExt, err)
+		q.done(s)
+
+		return nil
+	}
+
+	if err := q.store.Add(ctx, data...); err != nil {
+		var permanent *storage.PermanentError
+		if errors.As(err, &permanent) {
+			q.discard(s.name, rejectedExt, err)
+			q.done(s)
+
+			return nil
+		}
+
+		return fmt.Errorf("adding to store: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(q.config.Dir, s.name)); err != nil {
+		// the values would be sent again on restart, which is
+		// harmless as the store overwrites them
+		q.logger.Printf("write-ahead queue: removing segment: %v", err)
+	}
+
+	q.done(s)
+
+	return nil
+}
+
+// Done removes the given segment, which must be the oldest, from the
+// pending list.
+func (q *Queue) done(s segment) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.pending = q.pending[1:]
+	q.depth -= s.count
+}
+
+// Read returns the values in a segment file.
+func (q *Queue) read(name string) ([]*gym.Utilization, error) {
+	b, err := ioutil.ReadFile(filepath.Join(q.config.Dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("reading segment: %v", err)
+	}
+
+	var f segmentFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("decoding segment: %v", err)
+	}
+
+	if f.Version != segmentVersion {
+		return nil, fmt.Errorf("unsupported segment version %d", f.Version)
+	}
+
+	return f.Data, nil
+}
+
+// Discard renames a corrupt or rejected segment with the given
+// extension, so it is not read again, but is still available for
+// inspection.
+func (q *Queue) discard(name, ext string, reason error) {
+	q.logger.Printf("write-ahead queue: discarding segment %s: %v",
+		name, reason)
+
+	path := filepath.Join(q.config.Dir, name)
+	if err := os.Rename(path, path+ext); err != nil {
+		q.logger.Printf("write-ahead queue: renaming discarded segment: %v",
+			err)
+	}
+}
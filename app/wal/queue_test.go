@@ -0,0 +1,319 @@
+package wal_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+	"github.com/alcortesm/sputnik-popularity/app/wal"
+)
+
+// fakeStore is a wal.Adder that fails a number of times before
+// accepting values, and never accepts the ones with the invalid number
+// of people, if any.
+type fakeStore struct {
+	mutex    sync.Mutex
+	failures int
+	invalid  uint64
+	calls    int
+	data     []*gym.Utilization
+}
+
+func (s *fakeStore) Add(_ context.Context, data ...*gym.Utilization) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.calls++
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+
+	for _, d := range data {
+		if s.invalid != 0 && d.People == s.invalid {
+			return fmt.Errorf("adding: %w", &storage.PermanentError{
+				Err: errors.New("invalid value"),
+			})
+		}
+	}
+
+	s.data = append(s.data, data...)
+
+	return nil
+}
+
+func (s *fakeStore) Data() []*gym.Utilization {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.data
+}
+
+func logger(t *testing.T) *log.Logger {
+	return log.New(ioutil.Discard, t.Name(), 0)
+}
+
+func config(dir string) wal.Config {
+	return wal.Config{
+		Dir:        dir,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+	}
+}
+
+func utilization(people uint64) *gym.Utilization {
+	ts := time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+	return &gym.Utilization{
+		Gym:       "test",
+		Timestamp: ts.Add(time.Duration(people) * time.Minute),
+		People:    people,
+		Capacity:  100,
+	}
+}
+
+// run runs the queue until all its values have been forwarded.
+func run(t *testing.T, q *wal.Queue) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- q.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Depth() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout: %d values still pending", q.Depth())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]func(t *testing.T){
+		"forwards values in order":     forwardsInOrder,
+		"retries until store recovers": retriesUntilRecovery,
+		"survives restarts":            survivesRestarts,
+		"discards corrupt segments":    discardsCorrupt,
+		"discards rejected segments":   discardsRejected,
+		"rejects invalid backoffs":     rejectsInvalidBackoffs,
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			test(t)
+		})
+	}
+}
+
+func forwardsInOrder(t *testing.T) {
+	store := &fakeStore{}
+
+	q, err := wal.NewQueue(logger(t), store, config(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{
+		utilization(1), utilization(2), utilization(3),
+	}
+
+	ctx := context.Background()
+
+	if err := q.Add(ctx, want[0], want[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Add(ctx, want[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := q.Depth(); got != 3 {
+		t.Errorf("want depth 3, got %d", got)
+	}
+
+	run(t, q)
+
+	if diff := cmp.Diff(want, store.Data()); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func retriesUntilRecovery(t *testing.T) {
+	store := &fakeStore{failures: 3}
+
+	q, err := wal.NewQueue(logger(t), store, config(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{utilization(1)}
+
+	if err := q.Add(context.Background(), want...); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, q)
+
+	if diff := cmp.Diff(want, store.Data()); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+
+	if store.calls != 4 {
+		t.Errorf("want 4 calls to the store, got %d", store.calls)
+	}
+}
+
+func survivesRestarts(t *testing.T) {
+	dir := t.TempDir()
+
+	want := []*gym.Utilization{
+		utilization(1), utilization(2), utilization(3),
+	}
+
+	// the first queue is never run, like if the app was stopped while
+	// the store was down
+	{
+		q, err := wal.NewQueue(logger(t), &fakeStore{}, config(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, u := range want[:2] {
+			if err := q.Add(context.Background(), u); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	store := &fakeStore{}
+
+	q, err := wal.NewQueue(logger(t), store, config(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := q.Depth(); got != 2 {
+		t.Errorf("want depth 2 after restart, got %d", got)
+	}
+
+	// new values go after the ones from before the restart
+	if err := q.Add(context.Background(), want[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, q)
+
+	if diff := cmp.Diff(want, store.Data()); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func discardsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+
+	corrupt := filepath.Join(dir, "00000000000000000000.json")
+	if err := ioutil.WriteFile(corrupt, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &fakeStore{}
+
+	q, err := wal.NewQueue(logger(t), store, config(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := q.Depth(); got != 0 {
+		t.Errorf("want depth 0, got %d", got)
+	}
+
+	if _, err := os.Stat(corrupt + ".corrupt"); err != nil {
+		t.Errorf("corrupt segment not kept for inspection: %v", err)
+	}
+
+	want := []*gym.Utilization{utilization(1)}
+
+	if err := q.Add(context.Background(), want...); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, q)
+
+	if diff := cmp.Diff(want, store.Data()); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func discardsRejected(t *testing.T) {
+	dir := t.TempDir()
+	store := &fakeStore{invalid: 2}
+
+	q, err := wal.NewQueue(logger(t), store, config(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// the middle segment is rejected, the next one is still forwarded
+	for _, people := range []uint64{1, 2, 3} {
+		if err := q.Add(ctx, utilization(people)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(t, q)
+
+	want := []*gym.Utilization{utilization(1), utilization(3)}
+
+	if diff := cmp.Diff(want, store.Data()); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+
+	// rejected segments are not retried
+	if store.calls != 3 {
+		t.Errorf("want 3 calls to the store, got %d", store.calls)
+	}
+
+	rejected := filepath.Join(dir, "00000000000000000001.json.rejected")
+	if _, err := os.Stat(rejected); err != nil {
+		t.Errorf("rejected segment not kept for inspection: %v", err)
+	}
+}
+
+func rejectsInvalidBackoffs(t *testing.T) {
+	c := config(t.TempDir())
+	c.MaxBackoff = c.MinBackoff / 2
+
+	if _, err := wal.NewQueue(logger(t), &fakeStore{}, c); err == nil {
+		t.Error("unexpected success")
+	}
+}
@@ -0,0 +1,9 @@
+package web
+
+// exported for the tests in package web_test.
+var (
+	ChartWindow = chartWindow
+	ParseRange  = parseRange
+	Staleness   = staleness
+	GetRange    = Web.getRange
+)
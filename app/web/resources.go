@@ -11,110 +11,134 @@ canvas {
   height:auto;
 }`
 
-const chartTemplate = `var ctx = document.getElementById('chart').getContext('2d');
-
-const dataJSON = ` + "`{{.}}`;" + `
-const data = JSON.parse(dataJSON)
-
-var chart = new Chart(ctx, {
-    type: 'line',
-    data: {
-        datasets: [{
-            label: 'People',
-            yAxisID: 'people',
-            data: data.People,
-            backgroundColor: '#36a8e1',
-            borderColor: 'darkblue'
-        },
-        {
-            label: 'Capacity',
-            yAxisID: 'people',
-            data: data.Capacity,
-            backgroundColor: 'red',
-            borderColor: 'red',
-            fill: false
-        },
-        {
-            label: 'Percent',
-            yAxisID: 'percent',
-            data: data.Percent,
-			hidden: true,
-            backgroundColor: 'black',
-            borderColor: 'black',
-            fill: false
-        }]
-    },
-    options: {
-        padding: 10,
-        title: {
-            text: "Sputnik",
-            display: true,
-            fontColor: '#36a8e1',
-            fontSize: 20
-        },
-        elements: {
-            line: { tension: 0 },
-        },
-        scales: {
-            xAxes: [{
-                type: 'time',
-                time: {
-                    unit: 'day',
-                    displayFormats: {
-                        day: 'dddd, MMM D'
-                    }
-                }
-            }],
-            yAxes: [{
-                id: 'people',
-                position: 'left',
-                ticks: {
-                    min: 0,
-                    max: 250
-                },
-                scaleLabel: {
-                    display: true,
-                    labelString: '# of people'
-                }
+const chartTemplate = `const dataJSON = ` + "`{{.}}`;" + `
+const charts = JSON.parse(dataJSON)
+const container = document.getElementById('charts');
+
+charts.forEach(function (data) {
+    var canvas = document.createElement('canvas');
+    container.appendChild(canvas);
+    var ctx = canvas.getContext('2d');
+
+    new Chart(ctx, {
+        type: 'line',
+        data: {
+            datasets: [{
+                label: 'People',
+                yAxisID: 'people',
+                data: data.People,
+                backgroundColor: '#36a8e1',
+                borderColor: 'darkblue'
             },
             {
-                id: 'percent',
-                position: 'right',
-                ticks: {
-                    min: 0,
-                    max: 125
-                },
-                scaleLabel: {
-                    display: true,
-                    labelString: 'Percent of capacity'
-                },
-				gridLines: {
-                    drawOnChartArea: false // only want the grid lines for one axis to show up
-                }
+                label: 'Capacity',
+                yAxisID: 'people',
+                data: data.Capacity,
+                backgroundColor: 'red',
+                borderColor: 'red',
+                fill: false
+            },
+            {
+                label: 'Percent',
+                yAxisID: 'percent',
+                data: data.Percent,
+    			hidden: true,
+                backgroundColor: 'black',
+                borderColor: 'black',
+                fill: false
+            },
+            {
+                label: 'Closed',
+                yAxisID: 'closed',
+                data: data.Closed,
+                steppedLine: true,
+                pointRadius: 0,
+                borderWidth: 0,
+                backgroundColor: 'rgba(128, 128, 128, 0.2)',
+                fill: 'origin'
             }]
         },
-        tooltips: {
-            callbacks: {
-                title: function (tooltipItem, data) {
-                    var raw = tooltipItem[0].xLabel;
-                    var date = new Date(raw);
-
-                    var formatter = new Intl.DateTimeFormat('en-us', {
-                        weekday: 'long',
-                        month: 'short',
-                        day: 'numeric',
-                        hour: 'numeric',
-                        minute: 'numeric',
-                        hour12: false
-                    });
-
-                    var result = formatter.format(date);
-
-                    return result;
+        options: {
+            padding: 10,
+            title: {
+                // how stale the data is, below the name
+                text: data.Updated ? [data.Name, data.Updated] : data.Name,
+                display: true,
+                fontColor: '#36a8e1',
+                fontSize: 20
+            },
+            elements: {
+                line: { tension: 0 },
+            },
+            scales: {
+                xAxes: [{
+                    type: 'time',
+                    time: {
+                        unit: 'day',
+                        displayFormats: {
+                            day: 'dddd, MMM D'
+                        }
+                    }
+                }],
+                yAxes: [{
+                    id: 'people',
+                    position: 'left',
+                    ticks: {
+                        min: 0,
+                        max: 250
+                    },
+                    scaleLabel: {
+                        display: true,
+                        labelString: '# of people'
+                    }
+                },
+                {
+                    id: 'percent',
+                    position: 'right',
+                    ticks: {
+                        min: 0,
+                        max: 125
+                    },
+                    scaleLabel: {
+                        display: true,
+                        labelString: 'Percent of capacity'
+                    },
+    				gridLines: {
+                        drawOnChartArea: false // only want the grid lines for one axis to show up
+                    }
                 },
+                {
+                    id: 'closed',
+                    display: false,
+                    ticks: {
+                        min: 0,
+                        max: 1
+                    }
+                }]
+            },
+            tooltips: {
+                callbacks: {
+                    title: function (tooltipItem, data) {
+                        var raw = tooltipItem[0].xLabel;
+                        var date = new Date(raw);
+
+                        var formatter = new Intl.DateTimeFormat('en-us', {
+                            weekday: 'long',
+                            month: 'short',
+                            day: 'numeric',
+                            hour: 'numeric',
+                            minute: 'numeric',
+                            hour12: false
+                        });
+
+                        var result = formatter.format(date);
+
+                        return result;
+                    },
+                }
             }
         }
-    }
+    });
 });`
 
 const popularity = `<!DOCTYPE html>
@@ -128,13 +152,31 @@ const popularity = `<!DOCTYPE html>
 
 <body>
 
-  <div class="container">
-    <canvas id="chart"></canvas>
-  </div>
+  <form class="container" id="range" action="./popularity.html">
+    <label>From <input type="date" name="start" required></label>
+    <label>to <input type="date" name="end"></label>
+    <button type="submit">Show</button>
+    <a href="./popularity.html">Recent</a>
+  </form>
+
+  <div class="container" id="charts"></div>
 
 </body>
 
 <script src="https://cdnjs.cloudflare.com/ajax/libs/Chart.js/2.9.3/Chart.bundle.js"></script>
-<script src="./chart.js"></script>
+<script>
+  // forward the range in the query, if any, to the charts
+  const params = new URLSearchParams(window.location.search);
+  const form = document.getElementById('range');
+  ['start', 'end'].forEach(function (name) {
+      if (params.has(name)) {
+          form.elements[name].value = params.get(name);
+      }
+  });
+
+  const script = document.createElement('script');
+  script.src = './chart.js' + window.location.search;
+  document.body.appendChild(script);
+</script>
 
 </html>`
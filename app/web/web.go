@@ -7,9 +7,14 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/schedule"
+	"github.com/alcortesm/sputnik-popularity/app/scrape"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
 )
 
 var tmpl = template.Must(
@@ -22,8 +27,20 @@ var tmpl = template.Must(
 		Parse(chartTemplate))
 
 type Web struct {
-	Logger *log.Logger
-	Recent Getter
+	Logger   *log.Logger
+	Gyms     []Gym         // in the order they will be shown
+	Attempts AttemptGetter // nil if the attempts are not stored
+	Clock    func() time.Time
+	Queue    Depther     // nil if there is no write-ahead queue
+	History  RangeGetter // nil if only recent data can be shown
+}
+
+// Gym holds the sources of data to show for a gym.
+type Gym struct {
+	Name     string
+	Recent   Getter
+	Breaker  Stater
+	Schedule *schedule.Schedule // nil for gyms that are always open
 }
 
 // Getter knows how to get gym utilization data.
@@ -31,6 +48,30 @@ type Getter interface {
 	Get(context.Context) ([]*gym.Utilization, error)
 }
 
+// RangeGetter knows how to get the utilization data of all the gyms
+// between two times, see storage.Store.
+type RangeGetter interface {
+	GetRange(ctx context.Context, start, end time.Time) (
+		[]*gym.Utilization, error)
+}
+
+// AttemptGetter knows how to get the scraping attempts since a given
+// time, see influx.Store.
+type AttemptGetter interface {
+	GetAttempts(context.Context, time.Time) ([]*scrape.Attempt, error)
+}
+
+// Depther knows how many values are waiting in the write-ahead queue
+// to the database, see wal.Queue.
+type Depther interface {
+	Depth() int
+}
+
+// Stater knows the state of the circuit breaker of a gym scraper.
+type Stater interface {
+	State() scrape.State
+}
+
 func (w Web) PopularityHandler() http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-type", "text/html")
@@ -49,23 +90,177 @@ func (w Web) StyleHandler() http.Handler {
 	})
 }
 
-func (w Web) ChartHandler() http.Handler {
+// StatusHandler returns a handler that reports the status of the app
+// in JSON.
+func (w Web) StatusHandler() http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Set("Content-type", "application/javascript")
+		type gymStatus struct {
+			Name    string       `json:"name"`
+			Breaker scrape.State `json:"breaker"`
+		}
+
+		var payload struct {
+			Gyms []gymStatus `json:"gyms"`
+			// omitted if there is no write-ahead queue
+			QueueDepth *int `json:"queueDepth,omitempty"`
+		}
+
+		payload.Gyms = make([]gymStatus, len(w.Gyms))
+
+		for i, g := range w.Gyms {
+			payload.Gyms[i] = gymStatus{
+				Name:    g.Name,
+				Breaker: g.Breaker.State(),
+			}
+		}
+
+		if w.Queue != nil {
+			depth := w.Queue.Depth()
+			payload.QueueDepth = &depth
+		}
+
+		rw.Header().Set("Content-type", "application/json")
+
+		if err := json.NewEncoder(rw).Encode(payload); err != nil {
+			w.Logger.Printf("error writing HTTP response: %v", err)
+		}
+	})
+}
+
+// HealthHandler returns a handler that summarizes the scraping
+// attempts of each gym in JSON, see scrape.Health. The summary covers
+// the last 24 hours by default, use the hours query parameter to
+// change it.
+func (w Web) HealthHandler() http.Handler {
+	const defaultHours = 24
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if w.Attempts == nil {
+			http.Error(rw, "scraping attempts are not stored by "+
+				"this storage backend", http.StatusNotImplemented)
+			return
+		}
+
+		hours := defaultHours
 
-		dataRaw, err := w.Recent.Get(r.Context())
+		if raw := r.URL.Query().Get("hours"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				msg := fmt.Sprintf("invalid hours %q: "+
+					"want a positive integer", raw)
+				http.Error(rw, msg, http.StatusBadRequest)
+				return
+			}
+
+			hours = n
+		}
+
+		since := w.Clock().Add(-time.Duration(hours) * time.Hour)
+
+		attempts, err := w.Attempts.GetAttempts(r.Context(), since)
 		if err != nil {
-			msg := fmt.Sprintf("getting recent data: %v", err)
+			msg := fmt.Sprintf("getting scraping attempts: %v", err)
 			http.Error(rw, msg, http.StatusInternalServerError)
+			return
+		}
+
+		payload := struct {
+			Since time.Time        `json:"since"`
+			Gyms  []*scrape.Health `json:"gyms"`
+		}{
+			Since: since,
+			Gyms:  scrape.Summarize(attempts),
+		}
+
+		rw.Header().Set("Content-type", "application/json")
+
+		if err := json.NewEncoder(rw).Encode(payload); err != nil {
+			w.Logger.Printf("error writing HTTP response: %v", err)
 		}
+	})
+}
 
-		dataJSON, err := dataToJSON(dataRaw)
+// ChartHandler returns a handler that generates the javascript to draw
+// the charts of the gyms. It shows the recent data by default, use the
+// start and end query parameters to show the data in a time range
+// instead, see parseRange.
+//
+// Long time ranges are aggregated in time windows, see chartWindow,
+// with the function in the agg query parameter (mean by default).
+func (w Web) ChartHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start, end, isRange, err := parseRange(r.URL.Query(), w.Clock())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var byGym map[string][]*gym.Utilization
+
+		if isRange {
+			if w.History == nil {
+				http.Error(rw, "time ranges are not supported by "+
+					"this storage backend", http.StatusNotImplemented)
+				return
+			}
+
+			fn := storage.Mean
+
+			if raw := r.URL.Query().Get("agg"); raw != "" {
+				if err := fn.UnmarshalText([]byte(raw)); err != nil {
+					http.Error(rw, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			data, err := w.getRange(r.Context(), start, end, fn)
+			if err != nil {
+				msg := fmt.Sprintf("getting data from %s to %s: %v",
+					start.Format(time.RFC3339), end.Format(time.RFC3339),
+					err)
+				http.Error(rw, msg, http.StatusInternalServerError)
+				return
+			}
+
+			byGym = map[string][]*gym.Utilization{}
+			for _, d := range data {
+				byGym[d.Gym] = append(byGym[d.Gym], d)
+			}
+		}
+
+		charts := make([]chart, len(w.Gyms))
+
+		for i, g := range w.Gyms {
+			data, ok := byGym[g.Name]
+
+			if !isRange {
+				data, err = g.Recent.Get(r.Context())
+				if err != nil {
+					msg := fmt.Sprintf("getting recent data for gym %q: %v",
+						g.Name, err)
+					http.Error(rw, msg, http.StatusInternalServerError)
+					return
+				}
+			} else if !ok {
+				data = []*gym.Utilization{}
+			}
+
+			charts[i] = newChart(g.Name, data, g.Schedule)
+
+			if !isRange {
+				charts[i].Updated = staleness(w.Clock(), data)
+			}
+		}
+
+		dataJSON, err := chartsToJSON(charts)
 		if err != nil {
 			msg := fmt.Sprintf("marshaling data to JSON: %v", err)
 			http.Error(rw, msg, http.StatusInternalServerError)
 			return
 		}
 
+		rw.Header().Set("Content-type", "application/javascript")
+
 		if err := tmpl.Execute(rw, dataJSON); err != nil {
 			msg := fmt.Sprintf("executing template: %v", err)
 			http.Error(rw, msg, http.StatusInternalServerError)
@@ -74,34 +269,213 @@ func (w Web) ChartHandler() http.Handler {
 	})
 }
 
-func dataToJSON(data []*gym.Utilization) (template.HTML, error) {
-	type pairInt struct {
-		Timestamp time.Time `json:"t"`
-		Value     uint64    `json:"y"`
+// rawSpan is the longest time range to show without aggregating its
+// values.
+const rawSpan = 3 * 24 * time.Hour
+
+// maxChartPoints is the max number of aggregated values to show for a
+// gym, when possible.
+const maxChartPoints = 300
+
+// chartWindows are the windows to aggregate the values of long time
+// ranges, from shortest to longest.
+var chartWindows = []time.Duration{
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// chartWindow returns the shortest window to aggregate the values in a
+// time range of the given span so the chart is readable, or 0 if they
+// don't need to be aggregated.
+func chartWindow(span time.Duration) time.Duration {
+	if span <= rawSpan {
+		return 0
+	}
+
+	for _, w := range chartWindows {
+		if span/w <= maxChartPoints {
+			return w
+		}
+	}
+
+	return chartWindows[len(chartWindows)-1]
+}
+
+// getRange returns the values in a time range from the history,
+// aggregated with the given function if the range is long, see
+// chartWindow. Histories that can't aggregate their values, like the
+// bolt and file stores, are downsampled here.
+func (w Web) getRange(
+	ctx context.Context,
+	start, end time.Time,
+	fn storage.Aggregate,
+) ([]*gym.Utilization, error) {
+	window := chartWindow(end.Sub(start))
+	if window == 0 {
+		return w.History.GetRange(ctx, start, end)
+	}
+
+	if aggregator, ok := w.History.(storage.Aggregator); ok {
+		return aggregator.GetAggregated(ctx, start, end, window, fn)
+	}
+
+	data, err := w.History.GetRange(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.Downsample(data, window, fn)
+}
+
+// rangeDateLayout is the short layout accepted for the start and end
+// query parameters, for whole days in UTC.
+const rangeDateLayout = "2006-01-02"
+
+// parseRange returns the time range in the start and end query
+// parameters, which can be RFC 3339 timestamps or dates like
+// 2020-03-01, in UTC. The start is inclusive and the end exclusive, and
+// the end defaults to now. The returned bool is false if there is no
+// range in the query.
+func parseRange(
+	q url.Values,
+	now time.Time,
+) (start, end time.Time, ok bool, err error) {
+	rawStart, rawEnd := q.Get("start"), q.Get("end")
+
+	if rawStart == "" && rawEnd == "" {
+		return time.Time{}, time.Time{}, false, nil
+	}
+
+	if rawStart == "" {
+		return time.Time{}, time.Time{}, false,
+			fmt.Errorf("missing start: an end requires a start")
+	}
+
+	start, err = ParseTime(rawStart)
+	if err != nil {
+		return time.Time{}, time.Time{}, false,
+			fmt.Errorf("invalid start: %v", err)
+	}
+
+	end = now
+
+	if rawEnd != "" {
+		end, err = ParseTime(rawEnd)
+		if err != nil {
+			return time.Time{}, time.Time{}, false,
+				fmt.Errorf("invalid end: %v", err)
+		}
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, false,
+			fmt.Errorf("start (%s) must be before end (%s)",
+				start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	return start, end, true, nil
+}
+
+// ParseTime parses the times of the start and end query parameters,
+// RFC 3339 timestamps or dates like 2020-03-01, in UTC. The export
+// subcommand accepts the same times.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(rangeDateLayout, s); err == nil {
+		return t, nil
 	}
 
-	type pairFloat struct {
-		Timestamp time.Time `json:"t"`
-		Value     float64   `json:"y"`
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(
+			"want an RFC 3339 timestamp or a %s date, got %q",
+			rangeDateLayout, s)
 	}
 
-	payload := struct {
-		People   []pairInt
-		Capacity []pairInt
-		Percent  []pairFloat
-	}{
+	return t, nil
+}
+
+type pairInt struct {
+	Timestamp time.Time `json:"t"`
+	Value     uint64    `json:"y"`
+}
+
+type pairFloat struct {
+	Timestamp time.Time `json:"t"`
+	Value     float64   `json:"y"`
+}
+
+// chart is the data to draw the chart of a gym.
+type chart struct {
+	Name     string
+	People   []pairInt
+	Capacity []pairInt
+	Percent  []pairFloat
+	// Closed is a step function that is 1 while the gym is closed and
+	// 0 otherwise.
+	Closed []pairInt
+	// Updated says how stale the recent data is, empty for time
+	// ranges.
+	Updated string `json:",omitempty"`
+}
+
+// staleness describes how long ago the newest of the sorted values
+// was scraped, so stale data is not mistaken for current data.
+func staleness(now time.Time, data []*gym.Utilization) string {
+	if len(data) == 0 {
+		return "no recent data"
+	}
+
+	age := now.Sub(data[len(data)-1].Timestamp)
+
+	switch {
+	case age < time.Minute:
+		return "updated just now"
+	case age < time.Hour:
+		return fmt.Sprintf("updated %dm ago", age/time.Minute)
+	case age < 48*time.Hour:
+		return fmt.Sprintf("updated %dh ago", age/time.Hour)
+	default:
+		return fmt.Sprintf("updated %dd ago", age/(24*time.Hour))
+	}
+}
+
+func newChart(
+	name string,
+	data []*gym.Utilization,
+	sched *schedule.Schedule,
+) chart {
+	result := chart{
+		Name:     name,
 		People:   make([]pairInt, len(data)),
 		Capacity: make([]pairInt, len(data)),
 		Percent:  make([]pairFloat, len(data)),
+		Closed:   []pairInt{},
+	}
+
+	if len(data) != 0 {
+		first := data[0].Timestamp
+		last := data[len(data)-1].Timestamp
+
+		for _, c := range sched.Closed(first, last) {
+			result.Closed = append(result.Closed,
+				pairInt{Timestamp: c.Start, Value: 1},
+				pairInt{Timestamp: c.End, Value: 0},
+			)
+		}
 	}
 
 	for i, d := range data {
-		payload.People[i] = pairInt{
+		result.People[i] = pairInt{
 			Timestamp: d.Timestamp,
 			Value:     d.People,
 		}
 
-		payload.Capacity[i] = pairInt{
+		result.Capacity[i] = pairInt{
 			Timestamp: d.Timestamp,
 			Value:     d.Capacity,
 		}
@@ -111,13 +485,17 @@ func dataToJSON(data []*gym.Utilization) (template.HTML, error) {
 			p = 0.0
 		}
 
-		payload.Percent[i] = pairFloat{
+		result.Percent[i] = pairFloat{
 			Timestamp: d.Timestamp,
 			Value:     p,
 		}
 	}
 
-	b, err := json.Marshal(payload)
+	return result
+}
+
+func chartsToJSON(charts []chart) (template.HTML, error) {
+	b, err := json.Marshal(charts)
 	if err != nil {
 		return "", fmt.Errorf("generating JSON from data: %v", err)
 	}
@@ -0,0 +1,247 @@
+package web_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/alcortesm/sputnik-popularity/app/gym"
+	"github.com/alcortesm/sputnik-popularity/app/storage"
+	"github.com/alcortesm/sputnik-popularity/app/web"
+)
+
+func TestChartWindow(t *testing.T) {
+	t.Parallel()
+
+	const day = 24 * time.Hour
+
+	subtests := []struct {
+		span time.Duration
+		want time.Duration
+	}{
+		{span: time.Hour, want: 0},
+		{span: 3 * day, want: 0},
+		{span: 3*day + time.Second, want: 30 * time.Minute},
+		{span: 6 * day, want: 30 * time.Minute},
+		{span: 7 * day, want: time.Hour},
+		{span: 30 * day, want: 3 * time.Hour},
+		{span: 365 * day, want: 24 * time.Hour},
+		{span: 10 * 365 * day, want: 24 * time.Hour},
+	}
+
+	for _, test := range subtests {
+		test := test
+
+		t.Run(test.span.String(), func(t *testing.T) {
+			t.Parallel()
+
+			if got := web.ChartWindow(test.span); got != test.want {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+	subtests := map[string]struct {
+		query     string
+		wantStart time.Time
+		wantEnd   time.Time
+		wantOK    bool
+		wantErr   bool
+	}{
+		"no range": {
+			query: "",
+		},
+		"dates": {
+			query:     "start=2020-09-01&end=2020-09-03",
+			wantStart: time.Date(2020, time.September, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2020, time.September, 3, 0, 0, 0, 0, time.UTC),
+			wantOK:    true,
+		},
+		"timestamps": {
+			query:     "start=2020-09-01T10:00:00Z&end=2020-09-01T12:00:00%2B01:00",
+			wantStart: time.Date(2020, time.September, 1, 10, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2020, time.September, 1, 11, 0, 0, 0, time.UTC),
+			wantOK:    true,
+		},
+		"end defaults to now": {
+			query:     "start=2020-09-01",
+			wantStart: time.Date(2020, time.September, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   now,
+			wantOK:    true,
+		},
+		"end without start": {
+			query:   "end=2020-09-01",
+			wantErr: true,
+		},
+		"invalid start": {
+			query:   "start=yesterday",
+			wantErr: true,
+		},
+		"invalid end": {
+			query:   "start=2020-09-01&end=tomorrow",
+			wantErr: true,
+		},
+		"empty range": {
+			query:   "start=2020-09-01&end=2020-09-01",
+			wantErr: true,
+		},
+		"reversed range": {
+			query:   "start=2020-09-03&end=2020-09-01",
+			wantErr: true,
+		},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			q, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start, end, ok, err := web.ParseRange(q, now)
+			if test.wantErr {
+				if err == nil {
+					t.Error("unexpected success")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != test.wantOK {
+				t.Errorf("want ok %t, got %t", test.wantOK, ok)
+			}
+
+			if !start.Equal(test.wantStart) {
+				t.Errorf("want start %v, got %v", test.wantStart, start)
+			}
+
+			if !end.Equal(test.wantEnd) {
+				t.Errorf("want end %v, got %v", test.wantEnd, end)
+			}
+		})
+	}
+}
+
+func TestStaleness(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, time.October, 1, 18, 0, 0, 0, time.UTC)
+
+	subtests := map[string]struct {
+		age  time.Duration // of the newest value
+		want string
+	}{
+		"seconds": {age: 59 * time.Second, want: "updated just now"},
+		"minutes": {age: 59 * time.Minute, want: "updated 59m ago"},
+		"an hour": {age: time.Hour, want: "updated 1h ago"},
+		"hours":   {age: 47 * time.Hour, want: "updated 47h ago"},
+		"days":    {age: 50 * time.Hour, want: "updated 2d ago"},
+	}
+
+	for name, test := range subtests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			data := []*gym.Utilization{
+				{Timestamp: now.Add(-test.age - time.Hour)},
+				{Timestamp: now.Add(-test.age)},
+			}
+
+			if got := web.Staleness(now, data); got != test.want {
+				t.Errorf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+
+	t.Run("no data", func(t *testing.T) {
+		t.Parallel()
+
+		want := "no recent data"
+
+		if got := web.Staleness(now, nil); got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	})
+}
+
+// history is a web.RangeGetter that can't aggregate its values, like
+// the bolt and file stores.
+type history []*gym.Utilization
+
+func (h history) GetRange(_ context.Context, start, end time.Time) (
+	[]*gym.Utilization, error) {
+	result := []*gym.Utilization{}
+
+	for _, d := range h {
+		if !d.Timestamp.Before(start) && d.Timestamp.Before(end) {
+			result = append(result, d)
+		}
+	}
+
+	return result, nil
+}
+
+func TestGetRange_Downsamples(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2020, time.September, 1, 0, 0, 0, 0, time.UTC)
+
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	h := history{
+		{Gym: "a", Timestamp: at(1), People: 1, Capacity: 10},
+		{Gym: "a", Timestamp: at(2), People: 3, Capacity: 10},
+		{Gym: "a", Timestamp: at(40), People: 5, Capacity: 10},
+	}
+
+	w := web.Web{History: h}
+
+	ctx := context.Background()
+
+	// short ranges are not aggregated
+	got, err := web.GetRange(w, ctx, start, at(60), storage.Mean)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]*gym.Utilization(h), got); diff != "" {
+		t.Errorf("short range (-want +got)\n%s", diff)
+	}
+
+	// long ranges are aggregated in 30 minutes windows
+	got, err = web.GetRange(w, ctx, start, start.Add(6*24*time.Hour),
+		storage.Mean)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*gym.Utilization{
+		{Gym: "a", Timestamp: at(0), People: 2, Capacity: 10},
+		{Gym: "a", Timestamp: at(30), People: 5, Capacity: 10},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("long range (-want +got)\n%s", diff)
+	}
+}
@@ -6,5 +6,6 @@ require (
 	github.com/google/go-cmp v0.5.2
 	github.com/influxdata/influxdb-client-go/v2 v2.0.1
 	github.com/kelseyhightower/envconfig v1.4.0
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 )
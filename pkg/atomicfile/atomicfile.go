@@ -0,0 +1,50 @@
+// Package atomicfile writes files that are never left half written.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a file like ioutil.WriteFile, but atomically:
+// the data is written and flushed to disk in a temporary file in the
+// same directory, which is then renamed to the given path. A crash
+// while writing leaves the previous file, if any, untouched.
+//
+// The temporary file is named after the file, with a leading dot and a
+// ".tmp" extension, so readers of the directory can skip it.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+
+	if err := writeSynced(tmp, data, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// WriteSynced writes a file and flushes it to disk.
+func writeSynced(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}